@@ -0,0 +1,29 @@
+//go:build noembed
+
+// With -tags noembed the assets are read from an assets directory next to the
+// binary, falling back to ./assets in the working directory, so templates can
+// be customized without rebuilding.
+package assets
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var EmbeddedFiles = dirFS()
+
+func dirFS() fs.ReadFileFS {
+	dir := "assets"
+	if exe, err := os.Executable(); err == nil {
+		if candidate := filepath.Join(filepath.Dir(exe), "assets"); isDir(candidate) {
+			dir = candidate
+		}
+	}
+	return os.DirFS(dir).(fs.ReadFileFS)
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
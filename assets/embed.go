@@ -1,6 +1,16 @@
+//go:build !noembed
+
+// Package assets holds the filters, templates, static files, migrations and
+// API spec. Default builds embed them in the binary; build with -tags noembed
+// to read them from disk instead (see dir.go).
 package assets
 
-import "embed"
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed "filters/domains.json" "filters/ip_ranges.json" "static" "templates" "migrations" "openapi.json"
+var embedded embed.FS
 
-//go:embed "filters/domains.json" "static" "templates" "migrations"
-var EmbeddedFiles embed.FS
+var EmbeddedFiles fs.ReadFileFS = embedded
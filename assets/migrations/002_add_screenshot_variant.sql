@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE screenshots_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    variant TEXT NOT NULL DEFAULT '',
+    data BLOB NOT NULL,
+    content_type TEXT NOT NULL DEFAULT 'image/webp',
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (url, width, height, variant)
+);
+
+INSERT INTO screenshots_new (id, url, data, content_type, width, height, created_at)
+SELECT id, url, data, content_type, width, height, created_at FROM screenshots;
+
+DROP TABLE screenshots;
+
+ALTER TABLE screenshots_new RENAME TO screenshots;
+
+CREATE INDEX IF NOT EXISTS idx_screenshots_url ON screenshots(url);
+
+-- +goose Down
+CREATE TABLE screenshots_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL UNIQUE,
+    data BLOB NOT NULL,
+    content_type TEXT NOT NULL DEFAULT 'image/webp',
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR REPLACE INTO screenshots_old (id, url, data, content_type, width, height, created_at)
+SELECT id, url, data, content_type, width, height, created_at FROM screenshots WHERE variant = '' ORDER BY id;
+
+DROP TABLE screenshots;
+
+ALTER TABLE screenshots_old RENAME TO screenshots;
+
+CREATE INDEX IF NOT EXISTS idx_screenshots_url ON screenshots(url);
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE screenshots ADD COLUMN timing_json TEXT;
+
+-- +goose Down
+ALTER TABLE screenshots DROP COLUMN timing_json;
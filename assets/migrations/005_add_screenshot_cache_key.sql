@@ -0,0 +1,11 @@
+-- +goose Up
+-- Rows cached before this migration keep a NULL cache_key, so lookups miss
+-- them and the next capture of the same screenshot replaces them.
+ALTER TABLE screenshots ADD COLUMN cache_key TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_screenshots_cache_key ON screenshots(cache_key);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_screenshots_cache_key;
+
+ALTER TABLE screenshots DROP COLUMN cache_key;
@@ -0,0 +1,51 @@
+-- +goose Up
+CREATE TABLE screenshots_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    variant TEXT NOT NULL DEFAULT '',
+    format TEXT NOT NULL DEFAULT 'webp',
+    data BLOB NOT NULL,
+    content_type TEXT NOT NULL DEFAULT 'image/webp',
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    timing_json TEXT,
+    cache_key TEXT,
+    UNIQUE (url, width, height, format, variant)
+);
+
+INSERT INTO screenshots_new (id, url, variant, format, data, content_type, width, height, created_at, timing_json, cache_key)
+SELECT id, url, variant, COALESCE(NULLIF(REPLACE(content_type, 'image/', ''), ''), 'webp'), data, content_type, width, height, created_at, timing_json, cache_key
+FROM screenshots;
+
+DROP TABLE screenshots;
+
+ALTER TABLE screenshots_new RENAME TO screenshots;
+
+CREATE INDEX IF NOT EXISTS idx_screenshots_url ON screenshots(url);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_screenshots_cache_key ON screenshots(cache_key);
+
+-- +goose Down
+CREATE TABLE screenshots_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    variant TEXT NOT NULL DEFAULT '',
+    data BLOB NOT NULL,
+    content_type TEXT NOT NULL DEFAULT 'image/webp',
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    timing_json TEXT,
+    cache_key TEXT,
+    UNIQUE (url, width, height, variant)
+);
+
+INSERT OR REPLACE INTO screenshots_old (id, url, variant, data, content_type, width, height, created_at, timing_json, cache_key)
+SELECT id, url, variant, data, content_type, width, height, created_at, timing_json, cache_key FROM screenshots ORDER BY id;
+
+DROP TABLE screenshots;
+
+ALTER TABLE screenshots_old RENAME TO screenshots;
+
+CREATE INDEX IF NOT EXISTS idx_screenshots_url ON screenshots(url);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_screenshots_cache_key ON screenshots(cache_key);
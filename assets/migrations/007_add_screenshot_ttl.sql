@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE screenshots ADD COLUMN ttl_secs INTEGER;
+
+-- +goose Down
+ALTER TABLE screenshots DROP COLUMN ttl_secs;
@@ -0,0 +1,104 @@
+package main
+
+import "strings"
+
+type domainTrie struct {
+	root trieNode
+	size int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	exact    bool
+	wildcard bool
+}
+
+func newDomainTrie() *domainTrie {
+	return &domainTrie{}
+}
+
+func (t *domainTrie) Insert(entry string) bool {
+	domain, wildcard := strings.CutPrefix(entry, "*.")
+	if domain == "" {
+		return false
+	}
+
+	n := &t.root
+	for end := len(domain); end > 0; {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		label := domain[start:end]
+		child, ok := n.children[label]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*trieNode)
+			}
+			child = &trieNode{}
+			n.children[label] = child
+		}
+		n = child
+		end = start - 1
+	}
+
+	flag := &n.exact
+	if wildcard {
+		flag = &n.wildcard
+	}
+	if *flag {
+		return false
+	}
+	*flag = true
+	t.size++
+	return true
+}
+
+func (t *domainTrie) Match(host string) (string, bool) {
+	n := &t.root
+	matched, wildStart := "", -1
+
+	for end, labels := len(host), 1; end > 0; labels++ {
+		start := strings.LastIndexByte(host[:end], '.') + 1
+		child, ok := n.children[host[start:end]]
+		if !ok {
+			break
+		}
+		n = child
+
+		if n.exact && (start == 0 || labels > 1) {
+			matched = host[start:]
+		}
+		if n.wildcard && start > 0 {
+			wildStart = start
+		}
+		end = start - 1
+	}
+
+	if matched != "" {
+		return matched, true
+	}
+	if wildStart >= 0 {
+		return "*." + host[wildStart:], true
+	}
+	return "", false
+}
+
+func (t *domainTrie) Entries() []string {
+	entries := make([]string, 0, t.size)
+	var walk func(n *trieNode, suffix string)
+	walk = func(n *trieNode, suffix string) {
+		if n.exact {
+			entries = append(entries, suffix)
+		}
+		if n.wildcard {
+			entries = append(entries, "*."+suffix)
+		}
+		for label, child := range n.children {
+			name := label
+			if suffix != "" {
+				name += "." + suffix
+			}
+			walk(child, name)
+		}
+	}
+	walk(&t.root, "")
+	return entries
+}
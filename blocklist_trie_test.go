@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDomainTrieMatch(t *testing.T) {
+	trie := newDomainTrie()
+	for _, d := range []string{"example.com", "ads.example.com", "*.cdn.test", "com", "tracker.io"} {
+		trie.Insert(d)
+	}
+
+	tests := []struct {
+		host    string
+		matched string
+		ok      bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"x.ads.example.com", "ads.example.com", true},
+		{"img.cdn.test", "*.cdn.test", true},
+		{"a.b.cdn.test", "*.cdn.test", true},
+		{"cdn.test", "", false},
+		{"com", "com", true},
+		{"other.com", "", false},
+		{"notexample.com", "", false},
+		{"tracker.io.evil.net", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		matched, ok := trie.Match(tt.host)
+		if matched != tt.matched || ok != tt.ok {
+			t.Errorf("Match(%q) = %q, %v; want %q, %v", tt.host, matched, ok, tt.matched, tt.ok)
+		}
+	}
+}
+
+func TestDomainTrieEntries(t *testing.T) {
+	trie := newDomainTrie()
+	for _, d := range []string{"example.com", "*.example.com", "example.com", "a.b.org", "*."} {
+		trie.Insert(d)
+	}
+
+	entries := trie.Entries()
+	slices.Sort(entries)
+	want := []string{"*.example.com", "a.b.org", "example.com"}
+	if !slices.Equal(entries, want) {
+		t.Errorf("Entries() = %v, want %v", entries, want)
+	}
+	if trie.size != len(want) {
+		t.Errorf("size = %d, want %d", trie.size, len(want))
+	}
+}
+
+func TestDomainTrieMatchDoesNotAllocate(t *testing.T) {
+	trie := newDomainTrie()
+	trie.Insert("example.com")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		trie.Match("static.assets.example.com")
+		trie.Match("static.assets.unlisted.org")
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+const benchmarkDomains = 500_000
+
+func benchmarkHosts() []string {
+	return []string{
+		"ads.tracker42.example.com",
+		"static.assets.unlisted.example.org",
+		"d499999.net",
+		"www.news.site.co.uk",
+	}
+}
+
+func BenchmarkBlocklistMatch(b *testing.B) {
+	b.Run("map", func(b *testing.B) {
+		domains := make(map[string]struct{}, benchmarkDomains)
+		for i := range benchmarkDomains {
+			domains[fmt.Sprintf("d%d.net", i)] = struct{}{}
+		}
+		domains["tracker42.example.com"] = struct{}{}
+
+		match := func(host string) bool {
+			if _, ok := domains[host]; ok {
+				return true
+			}
+			parts := strings.Split(host, ".")
+			for i := 1; i < len(parts)-1; i++ {
+				if _, ok := domains[strings.Join(parts[i:], ".")]; ok {
+					return true
+				}
+			}
+			return false
+		}
+
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, host := range benchmarkHosts() {
+				match(host)
+			}
+		}
+	})
+
+	b.Run("trie", func(b *testing.B) {
+		trie := newDomainTrie()
+		for i := range benchmarkDomains {
+			trie.Insert(fmt.Sprintf("d%d.net", i))
+		}
+		trie.Insert("tracker42.example.com")
+
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, host := range benchmarkHosts() {
+				trie.Match(host)
+			}
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+const browserPingTimeout = 5 * time.Second
+
+var (
+	ErrPoolClosed = errors.New("browser pool closed")
+	// ErrBrowserFailure marks errors caused by the browser itself rather than
+	// the page being captured; only these trip the circuit breaker.
+	ErrBrowserFailure = errors.New("browser failure")
+)
+
+type BrowserPool struct {
+	bin     string
+	proxy   string
+	noProxy []string
+	slots   []*pooledBrowser
+	// launching holds a channel per slot while a browser for it starts up,
+	// so launches happen outside mu and concurrent callers wait on them.
+	launching []chan struct{}
+	crashes   []int64
+	next      atomic.Uint64
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{}
+	logger    *slog.Logger
+}
+
+type BrowserStats struct {
+	Slot    int   `json:"slot"`
+	Running bool  `json:"running"`
+	Crashes int64 `json:"crashes"`
+}
+
+type pooledBrowser struct {
+	browser  *rod.Browser
+	launcher *launcher.Launcher
+}
+
+func NewBrowserPool(bin string, size int, healthInterval time.Duration, logger *slog.Logger) *BrowserPool {
+	size = max(size, 1)
+	p := &BrowserPool{
+		bin:       bin,
+		slots:     make([]*pooledBrowser, size),
+		launching: make([]chan struct{}, size),
+		crashes:   make([]int64, size),
+		done:      make(chan struct{}),
+		logger:    logger,
+	}
+	if healthInterval > 0 {
+		go p.monitor(healthInterval)
+	}
+	return p
+}
+
+func (p *BrowserPool) AcquirePage() (*rod.Page, error) {
+	i := int((p.next.Add(1) - 1) % uint64(len(p.slots)))
+
+	b, err := p.browserAt(i)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBrowserFailure, err)
+	}
+
+	page, err := b.Page(proto.TargetCreateTarget{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: creating page: %w", ErrBrowserFailure, err)
+	}
+	return page, nil
+}
+
+func (p *BrowserPool) ReleasePage(page *rod.Page) {
+	page.Close()
+
+	b := page.Browser()
+	p.mu.Lock()
+	i := slices.IndexFunc(p.slots, func(pb *pooledBrowser) bool { return pb != nil && pb.browser == b })
+	p.mu.Unlock()
+	if i < 0 {
+		return
+	}
+
+	if _, err := b.Timeout(browserPingTimeout).Version(); err != nil {
+		p.replace(i, b, err)
+	}
+}
+
+func (p *BrowserPool) browserAt(i int) (*rod.Browser, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if pb := p.slots[i]; pb != nil {
+			p.mu.Unlock()
+			return pb.browser, nil
+		}
+		if wait := p.launching[i]; wait != nil {
+			p.mu.Unlock()
+			<-wait
+			continue
+		}
+		done := make(chan struct{})
+		p.launching[i] = done
+		p.mu.Unlock()
+
+		pb, err := p.launch()
+
+		p.mu.Lock()
+		p.launching[i] = nil
+		close(done)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		if p.closed {
+			p.mu.Unlock()
+			pb.browser.Close()
+			pb.launcher.Cleanup()
+			return nil, ErrPoolClosed
+		}
+		p.slots[i] = pb
+		p.mu.Unlock()
+
+		go p.watch(i, pb)
+		p.logger.Info("browser launched", slog.Int("slot", i))
+		return pb.browser, nil
+	}
+}
+
+func (p *BrowserPool) launch() (*pooledBrowser, error) {
+	l := p.newLauncher()
+
+	url, err := l.Launch()
+	if err != nil {
+		return nil, fmt.Errorf("launching browser: %w", err)
+	}
+
+	browser := rod.New().ControlURL(url)
+	if err := browser.Connect(); err != nil {
+		l.Kill()
+		return nil, fmt.Errorf("connecting to browser: %w", err)
+	}
+
+	return &pooledBrowser{browser: browser, launcher: l}, nil
+}
+
+func (p *BrowserPool) newLauncher() *launcher.Launcher {
+	l := launcher.New().
+		Bin(p.bin).
+		Headless(true).
+		Set("no-sandbox").
+		Set("disable-gpu").
+		Set("disable-dev-shm-usage").
+		Set("disable-extensions").
+		Set("disable-plugins").
+		Set("disable-background-networking").
+		Set("disable-background-timer-throttling").
+		Set("disable-backgrounding-occluded-windows").
+		Set("disable-renderer-backgrounding").
+		Set("disable-sync").
+		Set("disable-translate").
+		Set("disable-default-apps").
+		Set("no-first-run").
+		Set("hide-scrollbars").
+		Set("mute-audio")
+
+	if p.proxy != "" {
+		l = l.Proxy(p.proxy)
+		if len(p.noProxy) > 0 {
+			l = l.Set("proxy-bypass-list", strings.Join(p.noProxy, ";"))
+		}
+	}
+	return l
+}
+
+func (p *BrowserPool) watch(i int, pb *pooledBrowser) {
+	for range pb.browser.Event() {
+	}
+	p.replace(i, pb.browser, errors.New("browser disconnected"))
+}
+
+func (p *BrowserPool) monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			browsers := make([]*rod.Browser, len(p.slots))
+			for i, pb := range p.slots {
+				if pb != nil {
+					browsers[i] = pb.browser
+				}
+			}
+			p.mu.Unlock()
+
+			for i, b := range browsers {
+				if b == nil {
+					continue
+				}
+				if _, err := b.Timeout(browserPingTimeout).Version(); err != nil {
+					p.replace(i, b, err)
+				}
+			}
+		}
+	}
+}
+
+func (p *BrowserPool) replace(i int, b *rod.Browser, cause error) {
+	p.mu.Lock()
+	pb := p.slots[i]
+	if p.closed || pb == nil || pb.browser != b {
+		p.mu.Unlock()
+		return
+	}
+	p.slots[i] = nil
+	p.crashes[i]++
+	crashes := p.crashes[i]
+	p.mu.Unlock()
+
+	pb.launcher.Kill()
+	p.logger.Warn("browser unhealthy, launching replacement",
+		slog.Int("slot", i),
+		slog.Int64("crashes", crashes),
+		slog.String("error", cause.Error()),
+	)
+
+	go func() {
+		if _, err := p.browserAt(i); err != nil && !errors.Is(err, ErrPoolClosed) {
+			p.logger.Error("failed to launch replacement browser", slog.Int("slot", i), slog.String("error", err.Error()))
+		}
+	}()
+}
+
+func (p *BrowserPool) Stats() []BrowserStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]BrowserStats, len(p.slots))
+	for i, pb := range p.slots {
+		stats[i] = BrowserStats{Slot: i, Running: pb != nil, Crashes: p.crashes[i]}
+	}
+	return stats
+}
+
+func (p *BrowserPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	var errs []error
+	for i, pb := range p.slots {
+		if pb == nil {
+			continue
+		}
+		if err := pb.browser.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing browser %d: %w", i, err))
+		}
+		pb.launcher.Cleanup()
+		p.slots[i] = nil
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+)
+
+func TestBrowserPoolClosed(t *testing.T) {
+	pool := NewBrowserPool("chromium", 0, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if len(pool.slots) != 1 {
+		t.Fatalf("expected pool size to be at least 1, got %d", len(pool.slots))
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("unexpected error closing idle pool: %v", err)
+	}
+
+	if _, err := pool.AcquirePage(); !errors.Is(err, ErrPoolClosed) || !errors.Is(err, ErrBrowserFailure) {
+		t.Errorf("expected ErrPoolClosed as a browser failure, got %v", err)
+	}
+}
+
+func TestBrowserPoolReplace(t *testing.T) {
+	pool := NewBrowserPool(filepath.Join(t.TempDir(), "missing-chromium"), 2, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer pool.Close()
+
+	crashed := rod.New()
+	pool.slots[1] = &pooledBrowser{browser: crashed, launcher: launcher.New()}
+
+	pool.replace(1, rod.New(), errors.New("stale"))
+	if stats := pool.Stats(); !stats[1].Running || stats[1].Crashes != 0 {
+		t.Fatalf("expected replace of a stale browser to be ignored, got %+v", stats[1])
+	}
+
+	pool.replace(1, crashed, errors.New("browser disconnected"))
+	pool.replace(1, crashed, errors.New("browser disconnected"))
+
+	stats := pool.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 slots, got %d", len(stats))
+	}
+	if stats[1].Running {
+		t.Error("expected crashed slot to be cleared")
+	}
+	if stats[1].Crashes != 1 {
+		t.Errorf("expected 1 crash recorded, got %d", stats[1].Crashes)
+	}
+	if stats[0].Crashes != 0 {
+		t.Errorf("expected untouched slot to have no crashes, got %d", stats[0].Crashes)
+	}
+}
+
+func TestBrowserPoolLaunchOutsideLock(t *testing.T) {
+	pool := NewBrowserPool("chromium", 1, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	launch := make(chan struct{})
+	pool.launching[0] = launch
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := pool.browserAt(0)
+		errc <- err
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		pool.Stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Stats not to block while a browser is launching")
+	}
+
+	select {
+	case err := <-errc:
+		t.Fatalf("expected browserAt to wait for the in-flight launch, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.Close()
+	pool.mu.Lock()
+	pool.launching[0] = nil
+	pool.mu.Unlock()
+	close(launch)
+
+	if err := <-errc; !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed after the launch finished, got %v", err)
+	}
+}
+
+func TestBrowserPoolProxyFlags(t *testing.T) {
+	pool := NewBrowserPool("chromium", 1, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer pool.Close()
+
+	if args := pool.newLauncher().FormatArgs(); slices.ContainsFunc(args, func(arg string) bool { return strings.HasPrefix(arg, "--proxy-server") }) {
+		t.Errorf("expected no proxy flag by default, got %v", args)
+	}
+
+	pool.proxy = "http://proxy.internal:3128"
+	pool.noProxy = []string{"intranet.example", "*.corp.example"}
+
+	args := pool.newLauncher().FormatArgs()
+	for _, want := range []string{"--proxy-server=http://proxy.internal:3128", "--proxy-bypass-list=intranet.example;*.corp.example"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("expected %q in %v", want, args)
+		}
+	}
+}
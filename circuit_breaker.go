@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     circuitState
+	failures  int
+	threshold int
+	window    time.Duration
+	openedAt  time.Time
+	probing   bool
+	logger    *slog.Logger
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, window time.Duration, logger *slog.Logger) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: max(threshold, 1),
+		window:    window,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.window {
+			return ErrCircuitOpen
+		}
+		cb.transition(circuitHalfOpen)
+		cb.probing = true
+		return nil
+	case circuitHalfOpen:
+		if cb.probing {
+			return ErrCircuitOpen
+		}
+		cb.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+
+	// Errors from the target site (timeouts, missing selectors, failed
+	// scripts) say nothing about the browser, so they leave the count alone.
+	if err != nil && !errors.Is(err, ErrBrowserFailure) {
+		return
+	}
+
+	if err == nil {
+		cb.failures = 0
+		if cb.state != circuitClosed {
+			cb.transition(circuitClosed)
+		}
+		return
+	}
+
+	cb.failures++
+	if cb.state == circuitHalfOpen || (cb.state == circuitClosed && cb.failures >= cb.threshold) {
+		cb.openedAt = cb.now()
+		cb.transition(circuitOpen)
+	}
+}
+
+func (cb *CircuitBreaker) Cancel() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+}
+
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state.String()
+}
+
+func (cb *CircuitBreaker) transition(to circuitState) {
+	cb.logger.Warn("circuit breaker state changed",
+		slog.String("from", cb.state.String()),
+		slog.String("to", to.String()),
+		slog.Int("failures", cb.failures),
+	)
+	cb.state = to
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(3, 30*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	cb.now = func() time.Time { return now }
+
+	errBrowser := fmt.Errorf("%w: creating page: connection reset", ErrBrowserFailure)
+
+	for range 2 {
+		if err := cb.Allow(); err != nil {
+			t.Fatalf("expected closed breaker to allow, got %v", err)
+		}
+		cb.Record(errBrowser)
+	}
+	cb.Record(context.Canceled)
+	if cb.State() != "closed" {
+		t.Fatalf("expected closed below threshold, got %s", cb.State())
+	}
+
+	cb.Record(errBrowser)
+	if cb.State() != "open" {
+		t.Fatalf("expected open after threshold, got %s", cb.State())
+	}
+	if err := cb.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	now = now.Add(31 * time.Second)
+	if err := cb.Allow(); err != nil {
+		t.Fatalf("expected probe to be allowed after recovery window, got %v", err)
+	}
+	if cb.State() != "half-open" {
+		t.Fatalf("expected half-open, got %s", cb.State())
+	}
+	if err := cb.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected only one probe in half-open, got %v", err)
+	}
+
+	cb.Record(errBrowser)
+	if cb.State() != "open" {
+		t.Fatalf("expected failed probe to reopen, got %s", cb.State())
+	}
+
+	now = now.Add(31 * time.Second)
+	if err := cb.Allow(); err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	cb.Record(nil)
+	if cb.State() != "closed" {
+		t.Fatalf("expected successful probe to close, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreakerIgnoresSiteErrors(t *testing.T) {
+	cb := NewCircuitBreaker(2, 30*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	siteErrors := []error{
+		fmt.Errorf("navigation timeout: %w", context.DeadlineExceeded),
+		fmt.Errorf("load timeout: %w", context.DeadlineExceeded),
+		fmt.Errorf("wait_for timeout: %w", errors.New("element not found")),
+		errors.New("login form field not found: #user"),
+		fmt.Errorf("injecting css: %w", errors.New("eval error")),
+		ErrRobotsDisallowed,
+		context.Canceled,
+	}
+	for _, err := range siteErrors {
+		if err := cb.Allow(); err != nil {
+			t.Fatalf("expected closed breaker to allow, got %v", err)
+		}
+		cb.Record(err)
+	}
+	if cb.State() != "closed" {
+		t.Fatalf("expected site errors to leave the breaker closed, got %s", cb.State())
+	}
+
+	cb.Record(fmt.Errorf("%w: launching browser: exec failed", ErrBrowserFailure))
+	cb.Record(fmt.Errorf("%w: target crashed: %w", ErrBrowserFailure, context.DeadlineExceeded))
+	if cb.State() != "open" {
+		t.Fatalf("expected browser failures to open the breaker, got %s", cb.State())
+	}
+}
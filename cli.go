@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+type captureCommand struct {
+	URL    string
+	Output string
+	Opts   CaptureOptions
+}
+
+func parseCaptureFlags(args []string, output io.Writer) (captureCommand, error) {
+	fs := flag.NewFlagSet("capture", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	rawURL := fs.String("url", "", "url of the page to capture (required)")
+	out := fs.String("output", "", "file to write the screenshot to (required)")
+	preset := fs.String("preset", "thumb", "dimension preset")
+	width := fs.Int("width", 0, "viewport width, overrides the preset")
+	height := fs.Int("height", 0, "viewport height, overrides the preset")
+	format := fs.String("format", defaultFormat, "image format (webp, png or jpeg)")
+	dark := fs.Bool("dark", false, "emulate prefers-color-scheme: dark")
+	delay := fs.Int("delay", 0, "milliseconds to wait after load before capturing")
+
+	if err := fs.Parse(args); err != nil {
+		return captureCommand{}, err
+	}
+	if fs.NArg() > 0 {
+		return captureCommand{}, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	if *rawURL == "" {
+		return captureCommand{}, errors.New("--url is required")
+	}
+	if *out == "" {
+		return captureCommand{}, errors.New("--output is required")
+	}
+
+	dim, ok := lookupPreset(*preset)
+	if !ok {
+		return captureCommand{}, fmt.Errorf("unknown preset %q", *preset)
+	}
+	if *width < 0 || *height < 0 {
+		return captureCommand{}, errors.New("width and height must be positive")
+	}
+	dim.Width = clampDimension(*width, dim.Width, maxWidth)
+	dim.Height = clampDimension(*height, dim.Height, maxHeight)
+
+	switch *format {
+	case "webp", "png", "jpeg":
+	case "jpg":
+		*format = "jpeg"
+	default:
+		return captureCommand{}, fmt.Errorf("unsupported format %q", *format)
+	}
+
+	d := time.Duration(*delay) * time.Millisecond
+	if d < 0 || d > maxDelay {
+		return captureCommand{}, fmt.Errorf("delay must be between 0 and %d ms", maxDelay.Milliseconds())
+	}
+
+	return captureCommand{
+		URL:    normalizeURL(*rawURL),
+		Output: *out,
+		Opts: CaptureOptions{
+			Width:       dim.Width,
+			Height:      dim.Height,
+			Dark:        *dark,
+			Delay:       d,
+			ImageFormat: *format,
+		},
+	}, nil
+}
+
+func runCapture(args []string) error {
+	cmd, err := parseCaptureFlags(args, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := DefaultConfig()
+	cfg.StorageBackend = ""
+
+	logLevel := slog.LevelWarn
+	if cfg.Debug {
+		logLevel = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+	srv, err := NewServer(cfg, logger, nil)
+	if err != nil {
+		return fmt.Errorf("creating server: %w", err)
+	}
+	defer srv.Close()
+
+	ctx := context.Background()
+	if err := srv.validateTargetURL(ctx, cmd.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	data, timing, err := srv.captureWithRetry(ctx, cmd.URL, cmd.Opts)
+	if err != nil {
+		return fmt.Errorf("capturing %s: %w", cmd.URL, err)
+	}
+
+	if err := os.WriteFile(cmd.Output, data, 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", cmd.Output, err)
+	}
+
+	fmt.Printf("wrote %s (%d bytes) in %s\n", cmd.Output, len(data), timing.Total.Round(time.Millisecond))
+	return nil
+}
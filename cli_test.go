@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCaptureFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    captureCommand
+		wantErr string
+	}{
+		{
+			name: "preset",
+			args: []string{"--url", "example.com", "--preset", "og", "--output", "out.webp"},
+			want: captureCommand{
+				URL:    "https://example.com",
+				Output: "out.webp",
+				Opts:   CaptureOptions{Width: 1200, Height: 630, ImageFormat: "webp"},
+			},
+		},
+		{
+			name: "overrides",
+			args: []string{"-url", "http://example.com", "-output", "out.png", "-width", "640", "-height", "9999", "-format", "png", "-dark", "-delay", "250"},
+			want: captureCommand{
+				URL:    "http://example.com",
+				Output: "out.png",
+				Opts:   CaptureOptions{Width: 640, Height: maxHeight, ImageFormat: "png", Dark: true, Delay: 250 * time.Millisecond},
+			},
+		},
+		{
+			name: "jpg",
+			args: []string{"--url", "example.com", "--output", "out.jpg", "--format", "jpg"},
+			want: captureCommand{
+				URL:    "https://example.com",
+				Output: "out.jpg",
+				Opts:   CaptureOptions{Width: 800, Height: 420, ImageFormat: "jpeg"},
+			},
+		},
+		{name: "missing url", args: []string{"--output", "out.webp"}, wantErr: "--url is required"},
+		{name: "missing output", args: []string{"--url", "example.com"}, wantErr: "--output is required"},
+		{name: "unknown preset", args: []string{"--url", "example.com", "--output", "o", "--preset", "huge"}, wantErr: "unknown preset"},
+		{name: "bad format", args: []string{"--url", "example.com", "--output", "o", "--format", "gif"}, wantErr: "unsupported format"},
+		{name: "negative width", args: []string{"--url", "example.com", "--output", "o", "--width", "-1"}, wantErr: "must be positive"},
+		{name: "delay too long", args: []string{"--url", "example.com", "--output", "o", "--delay", "60000"}, wantErr: "delay must be"},
+		{name: "extra args", args: []string{"--url", "example.com", "--output", "o", "stray"}, wantErr: "unexpected arguments"},
+		{name: "unknown flag", args: []string{"--nope"}, wantErr: "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCaptureFlags(tt.args, io.Discard)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.URL != tt.want.URL || got.Output != tt.want.Output {
+				t.Errorf("got url=%q output=%q, want url=%q output=%q", got.URL, got.Output, tt.want.URL, tt.want.Output)
+			}
+			if got.Opts.Width != tt.want.Opts.Width || got.Opts.Height != tt.want.Opts.Height ||
+				got.Opts.ImageFormat != tt.want.Opts.ImageFormat || got.Opts.Dark != tt.want.Opts.Dark ||
+				got.Opts.Delay != tt.want.Opts.Delay {
+				t.Errorf("got opts %+v, want %+v", got.Opts, tt.want.Opts)
+			}
+		})
+	}
+}
+
+func TestCaptureOptionsFormat(t *testing.T) {
+	tests := []struct {
+		opts CaptureOptions
+		want string
+	}{
+		{CaptureOptions{}, "webp"},
+		{CaptureOptions{ImageFormat: "png"}, "png"},
+		{CaptureOptions{ImageFormat: "webp", Transparent: true}, "png"},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.Format(); got != tt.want {
+			t.Errorf("Format() for %+v = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+	if v := (CaptureOptions{Dark: true}).Variant(); v != "dark" {
+		t.Errorf("Variant() = %q, want dark", v)
+	}
+}
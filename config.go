@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+type configKind int
+
+const (
+	kindString configKind = iota
+	kindBool
+	kindInt
+	kindDuration
+	kindList
+)
+
+// Config file keys are the APP_* environment variable names, lowercased and
+// without the prefix. Keys not listed here are plain strings.
+var configKinds = map[string]configKind{
+	"allow_browser_interaction":     kindBool,
+	"allow_date_mocking":            kindBool,
+	"allow_extra_headers":           kindBool,
+	"allow_no_js":                   kindBool,
+	"allow_private_ips":             kindBool,
+	"allow_quality_override":        kindBool,
+	"allow_target_auth":             kindBool,
+	"auto_migrate":                  kindBool,
+	"block_fonts":                   kindBool,
+	"block_media":                   kindBool,
+	"enable_pprof":                  kindBool,
+	"respect_robots_txt":            kindBool,
+	"silence_health_logs":           kindBool,
+	"tls_auto_acme":                 kindBool,
+	"trust_proxy":                   kindBool,
+	"browser_pool_size":             kindInt,
+	"cache_ttl_secs":                kindInt,
+	"capture_retries":               kindInt,
+	"db_max_idle_conns":             kindInt,
+	"db_max_open_conns":             kindInt,
+	"evict_batch_size":              kindInt,
+	"max_cache_entries":             kindInt,
+	"max_concurrent_per_ip":         kindInt,
+	"max_goroutine_warn_threshold":  kindInt,
+	"max_ttl_secs":                  kindInt,
+	"browser_health_check_interval": kindDuration,
+	"capture_retry_delay":           kindDuration,
+	"db_conn_max_lifetime":          kindDuration,
+	"session_cookie_ttl":            kindDuration,
+	"allowed_css_hosts":             kindList,
+	"allowed_hosts":                 kindList,
+	"allowed_origins":               kindList,
+	"api_versions":                  kindList,
+	"blocked_url_patterns":          kindList,
+	"no_proxy_hosts":                kindList,
+}
+
+// LoadConfig reads a TOML config file and applies APP_* environment variables
+// on top of it, so the environment always wins.
+func LoadConfig(path string) (Config, error) {
+	var file map[string]any
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return Config{}, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(file))
+	for key, v := range file {
+		s, err := configValue(key, v)
+		if err != nil {
+			return Config{}, fmt.Errorf("config %s: %w", path, err)
+		}
+		values[envKey(key)] = s
+	}
+
+	read := make(map[string]bool)
+	cfg := configFrom(func(key string) string {
+		read[key] = true
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		}
+		return values[key]
+	})
+
+	var unknown []string
+	for key := range file {
+		if !read[envKey(key)] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return Config{}, fmt.Errorf("config %s: unknown keys: %s", path, strings.Join(unknown, ", "))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func envKey(key string) string {
+	return "APP_" + strings.ToUpper(key)
+}
+
+func configValue(key string, v any) (string, error) {
+	switch configKinds[key] {
+	case kindBool:
+		b, ok := v.(bool)
+		if !ok {
+			return "", fmt.Errorf("%s must be true or false", key)
+		}
+		return strconv.FormatBool(b), nil
+	case kindInt:
+		n, ok := v.(int64)
+		if !ok {
+			return "", fmt.Errorf("%s must be an integer", key)
+		}
+		if n < 0 || (n == 0 && key != "capture_retries") {
+			return "", fmt.Errorf("%s must be positive, got %d", key, n)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case kindDuration:
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%s must be a duration string such as \"30s\"", key)
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			return "", fmt.Errorf("%s: %w", key, err)
+		}
+		return s, nil
+	case kindList:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		items, ok := v.([]any)
+		if !ok {
+			return "", fmt.Errorf("%s must be a list of strings", key)
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("%s must be a list of strings", key)
+			}
+			list = append(list, s)
+		}
+		return strings.Join(list, ","), nil
+	default:
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%s must be a string", key)
+		}
+		return s, nil
+	}
+}
+
+func (c Config) Validate() error {
+	switch {
+	case c.Port == "" || c.Port == ":":
+		return errors.New("port must not be empty")
+	case c.PageTimeout <= 0:
+		return fmt.Errorf("page timeout must be positive, got %s", c.PageTimeout)
+	case c.MaxPageTimeout < c.PageTimeout:
+		return fmt.Errorf("max page timeout (%s) must not be below the page timeout (%s)", c.MaxPageTimeout, c.PageTimeout)
+	case c.MaxWidth <= 0 || c.MaxHeight <= 0:
+		return fmt.Errorf("max width and height must be positive, got %dx%d", c.MaxWidth, c.MaxHeight)
+	case c.ScreenshotQual < 1 || c.ScreenshotQual > 100:
+		return fmt.Errorf("screenshot quality must be between 1 and 100, got %d", c.ScreenshotQual)
+	case c.MaxConcurrent <= 0:
+		return fmt.Errorf("max concurrent must be positive, got %d", c.MaxConcurrent)
+	case c.StorageBackend != "sqlite" && c.StorageBackend != "redis":
+		return fmt.Errorf("storage_backend must be sqlite or redis, got %q", c.StorageBackend)
+	case c.StorageBackend == "redis" && c.RedisURL == "":
+		return errors.New("redis_url is required when storage_backend is redis")
+	case (c.TLSCertFile == "") != (c.TLSKeyFile == ""):
+		return errors.New("tls_cert_file and tls_key_file must be set together")
+	case c.TLSAutoACME && c.ACMEDomain == "":
+		return errors.New("acme_domain is required when tls_auto_acme is true")
+	}
+	for _, v := range c.APIVersions {
+		if !slices.Contains(supportedAPIVersions, v) {
+			return fmt.Errorf("unsupported api version %q", v)
+		}
+	}
+	for _, p := range c.BlockedURLPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("blocked_url_patterns: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfigFile(t, `
+port = "8080"
+max_cache_entries = 500
+capture_retries = 0
+db_conn_max_lifetime = "90s"
+trust_proxy = true
+block_fonts = false
+allowed_hosts = ["example.com", "*.example.org"]
+no_proxy_hosts = "a.internal, b.internal"
+`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Port != ":8080" {
+		t.Errorf("Port = %q, want :8080", cfg.Port)
+	}
+	if cfg.MaxCacheEntries != 500 {
+		t.Errorf("MaxCacheEntries = %d, want 500", cfg.MaxCacheEntries)
+	}
+	if cfg.CaptureRetries != 0 {
+		t.Errorf("CaptureRetries = %d, want 0", cfg.CaptureRetries)
+	}
+	if cfg.DBConnMaxLifetime != 90*time.Second {
+		t.Errorf("DBConnMaxLifetime = %s, want 90s", cfg.DBConnMaxLifetime)
+	}
+	if !cfg.TrustProxy || cfg.BlockFonts {
+		t.Errorf("TrustProxy = %v, BlockFonts = %v, want true, false", cfg.TrustProxy, cfg.BlockFonts)
+	}
+	if !slices.Equal(cfg.AllowedHosts, []string{"example.com", "*.example.org"}) {
+		t.Errorf("AllowedHosts = %v", cfg.AllowedHosts)
+	}
+	if !slices.Equal(cfg.NoProxyHosts, []string{"a.internal", "b.internal"}) {
+		t.Errorf("NoProxyHosts = %v", cfg.NoProxyHosts)
+	}
+	if cfg.EvictBatchSize != evictBatchSize {
+		t.Errorf("EvictBatchSize = %d, want default %d", cfg.EvictBatchSize, evictBatchSize)
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "port = \"8080\"\nmax_cache_entries = 500\ntrust_proxy = true\n")
+	t.Setenv("APP_PORT", "9090")
+	t.Setenv("APP_TRUST_PROXY", "false")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Port != ":9090" {
+		t.Errorf("Port = %q, want env value :9090", cfg.Port)
+	}
+	if cfg.TrustProxy {
+		t.Error("TrustProxy = true, want env value false")
+	}
+	if cfg.MaxCacheEntries != 500 {
+		t.Errorf("MaxCacheEntries = %d, want file value 500", cfg.MaxCacheEntries)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"syntax", "port = ", "reading config"},
+		{"unknown key", "prot = \"80\"\n", "unknown keys: prot"},
+		{"bool type", "trust_proxy = \"yes\"\n", "trust_proxy must be true or false"},
+		{"int type", "max_cache_entries = \"lots\"\n", "max_cache_entries must be an integer"},
+		{"int range", "browser_pool_size = 0\n", "browser_pool_size must be positive"},
+		{"duration", "capture_retry_delay = \"soon\"\n", "capture_retry_delay"},
+		{"string type", "port = 80\n", "port must be a string"},
+		{"list type", "allowed_hosts = [1, 2]\n", "allowed_hosts must be a list of strings"},
+		{"storage backend", "storage_backend = \"postgres\"\n", "storage_backend must be sqlite or redis"},
+		{"redis url", "storage_backend = \"redis\"\n", "redis_url is required"},
+		{"tls pair", "tls_cert_file = \"cert.pem\"\n", "must be set together"},
+		{"acme domain", "tls_auto_acme = true\n", "acme_domain is required"},
+		{"api version", "api_versions = [\"v9\"]\n", "unsupported api version"},
+		{"pattern", "blocked_url_patterns = [\"(\"]\n", "blocked_url_patterns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr string
+	}{
+		{"default", func(*Config) {}, ""},
+		{"page timeout", func(c *Config) { c.PageTimeout = 0 }, "page timeout must be positive"},
+		{"max page timeout", func(c *Config) { c.MaxPageTimeout = time.Second }, "max page timeout"},
+		{"dimensions", func(c *Config) { c.MaxWidth = 0 }, "max width and height"},
+		{"quality", func(c *Config) { c.ScreenshotQual = 101 }, "screenshot quality"},
+		{"port", func(c *Config) { c.Port = ":" }, "port must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join("assets", "config.example.toml")); err != nil {
+		t.Fatalf("example config does not load: %v", err)
+	}
+}
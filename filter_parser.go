@@ -4,31 +4,240 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	filterDir     = "assets/filters"
+	outputPath    = "assets/filters/domains.json"
+	checksumsPath = "assets/filters/SHA256SUMS"
+	maxListBytes  = 16 << 20
+)
+
+var sources = map[string]string{
+	"annoyances-cookies.txt":     "https://ublockorigin.github.io/uAssets/filters/annoyances-cookies.txt",
+	"annoyances-others.txt":      "https://ublockorigin.github.io/uAssets/filters/annoyances-others.txt",
+	"badware.txt":                "https://ublockorigin.github.io/uAssets/filters/badware.txt",
+	"filters.txt":                "https://ublockorigin.github.io/uAssets/filters/filters.txt",
+	"privacy.txt":                "https://ublockorigin.github.io/uAssets/filters/privacy.txt",
+	"easylist.txt":               "https://ublockorigin.github.io/uAssets/thirdparties/easylist.txt",
+	"easyprivacy.txt":            "https://ublockorigin.github.io/uAssets/thirdparties/easyprivacy.txt",
+	"easylist-annoyances.txt":    "https://ublockorigin.github.io/uAssets/thirdparties/easylist-annoyances.txt",
+	"easylist-chat.txt":          "https://ublockorigin.github.io/uAssets/thirdparties/easylist-chat.txt",
+	"easylist-newsletters.txt":   "https://ublockorigin.github.io/uAssets/thirdparties/easylist-newsletters.txt",
+	"easylist-notifications.txt": "https://ublockorigin.github.io/uAssets/thirdparties/easylist-notifications.txt",
+	"easylist-social.txt":        "https://ublockorigin.github.io/uAssets/thirdparties/easylist-social.txt",
+}
+
+var abpChecksum = regexp.MustCompile(`(?m)^\s*!\s*checksum[\s\-:]+([\w+/=]+).*\n`)
+
 func main() {
-	domains := make(map[string]struct{})
+	download := flag.Bool("download", false, "download the latest filter lists before parsing")
+	dryRun := flag.Bool("dry-run", false, "print the domain count delta without writing any files")
+	flag.Parse()
+
+	dir := filterDir
+	if *download {
+		tmp, err := os.MkdirTemp("", "filters-*")
+		if err != nil {
+			fail("error creating download directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		if err := downloadLists(tmp); err != nil {
+			fail("error downloading filter lists: %v", err)
+		}
+		dir = tmp
+	} else if err := verifyChecksums(dir); err != nil {
+		fail("error verifying filter lists: %v", err)
+	}
+
+	domainList, err := parseLists(dir)
+	if err != nil {
+		fail("error parsing filter lists: %v", err)
+	}
+
+	if *dryRun {
+		previous := 0
+		if data, err := os.ReadFile(outputPath); err == nil {
+			var existing []string
+			if err := json.Unmarshal(data, &existing); err == nil {
+				previous = len(existing)
+			}
+		}
+		fmt.Printf("\n%d unique domains (%+d from %s), nothing written\n", len(domainList), len(domainList)-previous, outputPath)
+		return
+	}
+
+	output, err := json.Marshal(domainList)
+	if err != nil {
+		fail("error marshaling JSON: %v", err)
+	}
 
-	filterDir := "assets/filters"
-	files, err := os.ReadDir(filterDir)
+	if *download {
+		if err := installLists(dir); err != nil {
+			fail("error installing filter lists: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(outputPath, output, 0644); err != nil {
+		fail("error writing output: %v", err)
+	}
+
+	fmt.Printf("\nwrote %d unique domains to %s\n", len(domainList), outputPath)
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func downloadLists(dir string) error {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	client := &http.Client{Timeout: time.Minute}
+	for _, name := range names {
+		data, err := fetchList(client, sources[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("downloaded %s: %d bytes\n", name, len(data))
+	}
+	return nil
+}
+
+func fetchList(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading filter directory: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxListBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxListBytes {
+		return nil, fmt.Errorf("list exceeds %d bytes", maxListBytes)
+	}
+
+	return data, validateList(data)
+}
+
+func validateList(data []byte) error {
+	header := data[:min(len(data), 4096)]
+	if !bytes.Contains(header, []byte("! Title:")) {
+		return errors.New("response does not look like a filter list")
+	}
+
+	m := abpChecksum.FindSubmatch(data)
+	if m == nil {
+		return nil
+	}
+
+	body := abpChecksum.ReplaceAll(data, nil)
+	body = bytes.ReplaceAll(body, []byte("\r"), nil)
+	body = regexp.MustCompile(`\n+`).ReplaceAll(body, []byte("\n"))
+	sum := md5.Sum(bytes.TrimRight(body, "\n"))
+	if got := strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "="); got != strings.TrimRight(string(m[1]), "=") {
+		return fmt.Errorf("checksum mismatch: list declares %s, content hashes to %s", m[1], got)
+	}
+	return nil
+}
+
+func installLists(dir string) error {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sums strings.Builder
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(filterDir, name), data, 0644); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	return os.WriteFile(checksumsPath, []byte(sums.String()), 0644)
+}
+
+func verifyChecksums(dir string) error {
+	data, err := os.ReadFile(checksumsPath)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		want, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return fmt.Errorf("malformed line in %s: %q", checksumsPath, line)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return fmt.Errorf("%s: sha256 %s does not match %s", name, got, checksumsPath)
+		}
+	}
+	return nil
+}
+
+func parseLists(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]struct{})
 	for _, f := range files {
 		if f.IsDir() || !strings.HasSuffix(f.Name(), ".txt") {
 			continue
 		}
 
-		path := filepath.Join(filterDir, f.Name())
+		path := filepath.Join(dir, f.Name())
 		file, err := os.Open(path)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "warning: failed to open %s: %v\n", f.Name(), err)
@@ -88,19 +297,7 @@ func main() {
 	}
 	sort.Strings(domainList)
 
-	output, err := json.Marshal(domainList)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error marshaling JSON: %v\n", err)
-		os.Exit(1)
-	}
-
-	outputPath := "assets/filters/domains.json"
-	if err := os.WriteFile(outputPath, output, 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "error writing output: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Printf("\nwrote %d unique domains to %s\n", len(domainList), outputPath)
+	return domainList, nil
 }
 
 func isIPAddress(s string) bool {
@@ -1,33 +1,55 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"hash/fnv"
 	"html/template"
+	"image"
+	"io"
+	"io/fs"
 	"log"
 	"log/slog"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/cdp"
 	"github.com/go-rod/rod/lib/launcher"
 	"github.com/go-rod/rod/lib/proto"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose/v3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/text/language"
 
 	"github.com/wajeht/screenshot/assets"
 )
@@ -39,29 +61,126 @@ const (
 )
 
 const (
-	defaultPort         = "80"
-	defaultEnv          = "development"
-	defaultPassword     = ""
-	pageTimeout         = 30 * time.Second
-	screenshotQuality   = 50
-	cacheTTL            = 300
-	maxWidth            = 1920
-	maxHeight           = 1920
-	maxConcurrent       = 10
-	shutdownTimeout     = 30 * time.Second
-	readTimeout         = 5 * time.Second
-	writeTimeout        = 60 * time.Second
-	idleTimeout         = 120 * time.Second
-	minUserAgentLen     = 20
-	staticCacheTTL      = 86400
-	screenshotsCacheTTL = 60
+	defaultPort                = "80"
+	defaultEnv                 = "development"
+	defaultPassword            = ""
+	defaultBasicAuthRealm      = "Screenshot API"
+	pageTimeout                = 30 * time.Second
+	screenshotQuality          = 50
+	cacheTTL                   = 300
+	maxTTLSecs                 = 86400
+	maxWidth                   = 1920
+	maxHeight                  = 1920
+	maxConcurrent              = 10
+	maxConcurrentPerIP         = 3
+	maxCacheEntries            = 10000
+	evictBatchSize             = 100
+	cacheSweepInterval         = time.Minute
+	maxGoroutineWarnThreshold  = 1000
+	captureRetries             = 2
+	captureRetryDelay          = 500 * time.Millisecond
+	ipSweepInterval            = 5 * time.Minute
+	browserPoolSize            = 2
+	browserHealthCheckInterval = 30 * time.Second
+	cbFailureThreshold         = 5
+	cbRecoveryWindow           = 30 * time.Second
+	warmUpTimeout              = 10 * time.Minute
+	warmUpIP                   = "warm-up"
+	networkIdleWindow          = 600 * time.Millisecond
+	robotsTTL                  = time.Hour
+	maxScrollSteps             = 20
+	scrollStepInterval         = 200 * time.Millisecond
+	maxBatchSize               = 20
+	maxBatchBodyBytes          = 1 << 20
+	maxCSSBytes                = 64 * 1024
+	cssFetchTimeout            = 3 * time.Second
+	healthCheckTimeout         = 5 * time.Second
+	corsMaxAge                 = 86400
+	defaultAuditLimit          = 100
+	defaultStorage             = "sqlite"
+	redisTTL                   = 24 * time.Hour
+	maxURLLength               = 2048
+	minPageTimeout             = 5 * time.Second
+	maxPageTimeout             = 120 * time.Second
+	defaultACMECacheDir        = "./data/certs"
+	defaultRedirectPort        = "80"
+	maxPurgeRows               = 10000
+	defaultFormat              = "webp"
+	defaultRobotsUA            = "screenshotbot/1.0"
+	topBlockedDomains          = 10
+	maxTrackedBlocked          = 1000
+	maxAuditLimit              = 1000
+	maxDelay                   = 10 * time.Second
+	maxSelectorLen             = 256
+	shutdownTimeout            = 30 * time.Second
+	readTimeout                = 5 * time.Second
+	writeTimeout               = 60 * time.Second
+	idleTimeout                = 120 * time.Second
+	minUserAgentLen            = 20
+	staticCacheTTL             = 86400
+	screenshotsCacheTTL        = 60
+	defaultPerPage             = 50
+	maxPerPage                 = 200
+	maxFilenameHostLen         = 64
 )
 
+const (
+	requestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+const sessionCookieName = "screenshot_session"
+
+var Version = "dev"
+
+var supportedAPIVersions = []string{"v1"}
+
+const swaggerUIURL = "https://petstore.swagger.io/"
+
 var (
-	ErrNotFound       = errors.New("screenshot not found")
-	ErrBrowserMissing = errors.New("browser not found")
+	ErrNotFound            = errors.New("screenshot not found")
+	ErrBrowserMissing      = errors.New("browser not found")
+	ErrCacheWrite          = errors.New("failed to write cache")
+	ErrDatabaseCorrupt     = errors.New("database integrity check failed")
+	ErrInvalidRange        = errors.New("invalid range")
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+	ErrHostNotAllowed      = errors.New("url host is not allowed")
+
+	errBrowserBusy = errors.New("no capture slot free for the browser check")
 )
 
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+const preloadCSSScript = `(() => {
+	const css = %s;
+	const inject = () => {
+		const style = document.createElement('style');
+		style.textContent = css;
+		(document.head || document.documentElement).prepend(style);
+	};
+	if (document.documentElement) {
+		inject();
+		return;
+	}
+	new MutationObserver((_, observer) => {
+		if (document.documentElement) {
+			observer.disconnect();
+			inject();
+		}
+	}).observe(document, { childList: true });
+})()`
+
+const mockDateScript = `(() => {
+	const ms = %d;
+	Date = new Proxy(Date, {
+		construct(T, a) { return a.length ? new T(...a) : new T(ms); },
+		apply(T) { return new T(ms).toString(); },
+	});
+	Date.now = () => ms;
+})()`
+
 var botPattern = regexp.MustCompile(`(?i)bot|crawler|spider|crawling|googlebot|bingbot|yandex|baidu|duckduckbot|slurp|ia_archiver|facebookexternalhit|twitterbot|linkedinbot|embedly|quora|pinterest|slackbot|discordbot|telegrambot|whatsapp|applebot|semrush|ahref|mj12bot|dotbot|petalbot|curl|wget|python|httpie|postman|insomnia|java|ruby|perl|php|go-http-client|scrapy|httpclient|apache-http|okhttp`)
 
 var presets = map[string]Dimension{
@@ -73,6 +192,8 @@ var presets = map[string]Dimension{
 	"desktop": {Width: 1920, Height: 1080},
 }
 
+var presetsMu sync.RWMutex
+
 var blockedExtensions = map[string]struct{}{
 	".mp4": {}, ".webm": {}, ".mp3": {}, ".wav": {}, ".ogg": {},
 	".ico": {}, ".webmanifest": {},
@@ -96,35 +217,321 @@ var criticalDomains = []string{
 }
 
 type Config struct {
-	Port            string
-	PageTimeout     time.Duration
-	ScreenshotQual  int
-	CacheTTLSecs    int
-	MaxWidth        int
-	MaxHeight       int
-	MaxConcurrent   int
-	ShutdownTimeout time.Duration
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	IdleTimeout     time.Duration
-	MinUserAgentLen int
-	Debug           bool
-	BlockFonts      bool
-	BlockMedia      bool
-	Password        string
+	Port                       string
+	PageTimeout                time.Duration
+	MaxPageTimeout             time.Duration
+	ScreenshotQual             int
+	CacheTTLSecs               int
+	MaxTTLSecs                 int
+	MaxWidth                   int
+	MaxHeight                  int
+	MaxConcurrent              int
+	MaxConcurrentPerIP         int
+	MaxCacheEntries            int
+	EvictBatchSize             int
+	DBMaxOpenConns             int
+	DBMaxIdleConns             int
+	DBConnMaxLifetime          time.Duration
+	MaxGoroutineWarnThreshold  int
+	CaptureRetries             int
+	CaptureRetryDelay          time.Duration
+	BrowserPoolSize            int
+	BrowserHealthCheckInterval time.Duration
+	CBFailureThreshold         int
+	CBRecoveryWindow           time.Duration
+	WarmUpFile                 string
+	SilenceHealthLogs          bool
+	SessionCookieTTL           time.Duration
+	MaxScrollSteps             int
+	WarmUpURLs                 []string
+	BrowserUserAgent           string
+	BrowserProxy               string
+	NoProxyHosts               []string
+	AllowedHosts               []string
+	APIVersions                []string
+	RespectRobotsTxt           bool
+	RobotsFetchUA              string
+	OTELEndpoint               string
+	RobotsTTL                  time.Duration
+	MaxBatchSize               int
+	MaxCSSBytes                int
+	AllowedCSSHosts            []string
+	HealthCheckTimeout         time.Duration
+	AllowedOrigins             []string
+	AllowQualityOverride       bool
+	BlockedURLPatterns         []string
+	AllowExtraHeaders          bool
+	AllowTargetAuth            bool
+	AllowBrowserInteraction    bool
+	AllowDateMocking           bool
+	WatermarkPath              string
+	AllowNoJS                  bool
+	StorageBackend             string
+	RedisURL                   string
+	RedisTTL                   time.Duration
+	MaxURLLength               int
+	TLSCertFile                string
+	TLSKeyFile                 string
+	TLSAutoACME                bool
+	ACMEDomain                 string
+	ACMECacheDir               string
+	HTTPRedirectPort           string
+	TrustProxy                 bool
+	MaxPurgeRows               int
+	AllowPrivateIPs            bool
+	ShutdownTimeout            time.Duration
+	ReadTimeout                time.Duration
+	WriteTimeout               time.Duration
+	IdleTimeout                time.Duration
+	MinUserAgentLen            int
+	Debug                      bool
+	EnablePprof                bool
+	BlockFonts                 bool
+	BlockMedia                 bool
+	AutoMigrate                bool
+	Password                   string
+	BasicAuthRealm             string
+}
+
+type ConfigView struct {
+	Port                       string   `json:"port"`
+	PageTimeout                string   `json:"page_timeout"`
+	MaxPageTimeout             string   `json:"max_page_timeout"`
+	ScreenshotQual             int      `json:"screenshot_quality"`
+	CacheTTLSecs               int      `json:"cache_ttl_secs"`
+	MaxTTLSecs                 int      `json:"max_ttl_secs"`
+	MaxWidth                   int      `json:"max_width"`
+	MaxHeight                  int      `json:"max_height"`
+	MaxConcurrent              int      `json:"max_concurrent"`
+	MaxConcurrentPerIP         int      `json:"max_concurrent_per_ip"`
+	MaxCacheEntries            int      `json:"max_cache_entries"`
+	EvictBatchSize             int      `json:"evict_batch_size"`
+	DBMaxOpenConns             int      `json:"db_max_open_conns"`
+	DBMaxIdleConns             int      `json:"db_max_idle_conns"`
+	DBConnMaxLifetime          string   `json:"db_conn_max_lifetime"`
+	MaxGoroutineWarnThreshold  int      `json:"max_goroutine_warn_threshold"`
+	CaptureRetries             int      `json:"capture_retries"`
+	CaptureRetryDelay          string   `json:"capture_retry_delay"`
+	BrowserPoolSize            int      `json:"browser_pool_size"`
+	BrowserHealthCheckInterval string   `json:"browser_health_check_interval"`
+	CBFailureThreshold         int      `json:"cb_failure_threshold"`
+	CBRecoveryWindow           string   `json:"cb_recovery_window"`
+	WarmUpFile                 string   `json:"warm_up_file"`
+	SilenceHealthLogs          bool     `json:"silence_health_logs"`
+	SessionCookieTTL           string   `json:"session_cookie_ttl"`
+	MaxScrollSteps             int      `json:"max_scroll_steps"`
+	WarmUpURLs                 []string `json:"warm_up_urls"`
+	BrowserUserAgent           string   `json:"browser_user_agent"`
+	BrowserProxy               string   `json:"browser_proxy"`
+	NoProxyHosts               []string `json:"no_proxy_hosts"`
+	AllowedHosts               []string `json:"allowed_hosts"`
+	APIVersions                []string `json:"api_versions"`
+	RespectRobotsTxt           bool     `json:"respect_robots_txt"`
+	RobotsFetchUA              string   `json:"robots_fetch_ua"`
+	OTELEndpoint               string   `json:"otel_endpoint"`
+	RobotsTTL                  string   `json:"robots_ttl"`
+	MaxBatchSize               int      `json:"max_batch_size"`
+	MaxCSSBytes                int      `json:"max_css_bytes"`
+	AllowedCSSHosts            []string `json:"allowed_css_hosts"`
+	HealthCheckTimeout         string   `json:"health_check_timeout"`
+	AllowedOrigins             []string `json:"allowed_origins"`
+	AllowQualityOverride       bool     `json:"allow_quality_override"`
+	BlockedURLPatterns         []string `json:"blocked_url_patterns"`
+	AllowExtraHeaders          bool     `json:"allow_extra_headers"`
+	AllowTargetAuth            bool     `json:"allow_target_auth"`
+	AllowBrowserInteraction    bool     `json:"allow_browser_interaction"`
+	AllowDateMocking           bool     `json:"allow_date_mocking"`
+	WatermarkPath              string   `json:"watermark_path"`
+	AllowNoJS                  bool     `json:"allow_no_js"`
+	StorageBackend             string   `json:"storage_backend"`
+	RedisURL                   string   `json:"redis_url"`
+	RedisTTL                   string   `json:"redis_ttl"`
+	MaxURLLength               int      `json:"max_url_length"`
+	TLSCertFile                string   `json:"tls_cert_file"`
+	TLSKeyFile                 string   `json:"tls_key_file"`
+	TLSAutoACME                bool     `json:"tls_auto_acme"`
+	ACMEDomain                 string   `json:"acme_domain"`
+	ACMECacheDir               string   `json:"acme_cache_dir"`
+	HTTPRedirectPort           string   `json:"http_redirect_port"`
+	TrustProxy                 bool     `json:"trust_proxy"`
+	MaxPurgeRows               int      `json:"max_purge_rows"`
+	AllowPrivateIPs            bool     `json:"allow_private_ips"`
+	ShutdownTimeout            string   `json:"shutdown_timeout"`
+	ReadTimeout                string   `json:"read_timeout"`
+	WriteTimeout               string   `json:"write_timeout"`
+	IdleTimeout                string   `json:"idle_timeout"`
+	MinUserAgentLen            int      `json:"min_user_agent_len"`
+	Debug                      bool     `json:"debug"`
+	EnablePprof                bool     `json:"enable_pprof"`
+	BlockFonts                 bool     `json:"block_fonts"`
+	BlockMedia                 bool     `json:"block_media"`
+	AutoMigrate                bool     `json:"auto_migrate"`
+	Password                   string   `json:"password"`
+	BasicAuthRealm             string   `json:"basic_auth_realm"`
 }
 
 type Dimension struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type CIDRRequest struct {
+	CIDR string `json:"cidr"`
+}
+
+type PresetRequest struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type CaptureOptions struct {
+	Width           int
+	Height          int
+	FullPage        bool
+	CSS             string
+	Quality         int
+	Locale          string
+	Timezone        string
+	Referer         string
+	AuthUser        string
+	AuthPass        string
+	WaitFor         string
+	Delay           time.Duration
+	Clip            *Clip
+	Timeout         time.Duration
+	Transparent     bool
+	PreloadCSS      string
+	NetworkIdle     bool
+	ScrollDown      bool
+	Media           string
+	StripGA         bool
+	TTL             time.Duration
+	Dark            bool
+	ImageFormat     string
+	BlockThirdParty bool
+	NoCache         bool
+	Login           *LoginForm
+	MockDate        time.Time
+	AboveFold       bool
+	Watermark       string
+	NoJS            bool
+}
+
+type LoginForm struct {
+	FormSelector   string
+	UserSelector   string
+	PassSelector   string
+	SubmitSelector string
+	User           string
+	Pass           string
+}
+
+type Clip struct {
+	X      int
+	Y      int
 	Width  int
 	Height int
 }
 
 type Timing struct {
-	Setup      time.Duration
-	Navigation time.Duration
-	Load       time.Duration
-	Screenshot time.Duration
-	Total      time.Duration
+	Queue      time.Duration `json:"queue"`
+	Setup      time.Duration `json:"setup"`
+	Navigation time.Duration `json:"navigation"`
+	Load       time.Duration `json:"load"`
+	Screenshot time.Duration `json:"screenshot"`
+	Total      time.Duration `json:"total"`
+}
+
+type BatchRequest struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type BatchResult struct {
+	URL         string `json:"url"`
+	Data        []byte `json:"data,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Error       string `json:"error,omitempty"`
+	timing      Timing
+}
+
+type RepositoryStats struct {
+	TotalRows     int64            `json:"total_rows"`
+	TotalBytes    int64            `json:"total_bytes"`
+	BytesByFormat map[string]int64 `json:"bytes_by_format"`
+	Oldest        *time.Time       `json:"oldest,omitempty"`
+	Newest        *time.Time       `json:"newest,omitempty"`
+}
+
+type PurgeRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+type StatsResponse struct {
+	Repository  RepositoryStats `json:"repository"`
+	CacheHits   int64           `json:"cache_hits"`
+	CacheMisses int64           `json:"cache_misses"`
+	HitRate     float64         `json:"hit_rate"`
+	Browsers    []BrowserStats  `json:"browsers,omitempty"`
+}
+
+type PingResponse struct {
+	TS      int64  `json:"ts"`
+	Version string `json:"version"`
+}
+
+type VersionResponse struct {
+	APIVersion    string `json:"api_version"`
+	ServerVersion string `json:"server_version"`
+}
+
+type HealthStatus struct {
+	Status       string  `json:"status"`
+	Captures     int64   `json:"captures"`
+	Errors       int64   `json:"errors"`
+	CacheHitRate float64 `json:"cache_hit_rate"`
+}
+
+type DeepHealth struct {
+	DB                 string   `json:"db"`
+	Cache              string   `json:"cache,omitempty"`
+	Browser            string   `json:"browser"`
+	SemaphoreAvailable int      `json:"semaphore_available"`
+	UptimeSeconds      int64    `json:"uptime_seconds"`
+	CacheHits          int64    `json:"cache_hits"`
+	CacheMisses        int64    `json:"cache_misses"`
+	GoroutineCount     int      `json:"goroutine_count"`
+	MemoryAllocMB      float64  `json:"memory_alloc_mb"`
+	MemorySysMB        float64  `json:"memory_sys_mb"`
+	NumGC              uint32   `json:"num_gc"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+type CaptureRecord struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Format     string    `json:"format"`
+	RemoteIP   string    `json:"remote_ip"`
+	UserAgent  string    `json:"user_agent"`
+	StatusCode int       `json:"status_code"`
+	DurationMs int64     `json:"duration_ms"`
+	CacheHit   bool      `json:"cache_hit"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type AuditPage struct {
+	Captures   []CaptureRecord `json:"captures"`
+	NextOffset int             `json:"next_offset,omitempty"`
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
 }
 
 type PageData struct {
@@ -133,7 +540,7 @@ type PageData struct {
 	Message string
 }
 
-type ScreenshotEntry struct {
+type ScreenshotMeta struct {
 	ID          int    `json:"id"`
 	URL         string `json:"url"`
 	DataSize    int    `json:"data_size"`
@@ -145,66 +552,504 @@ type ScreenshotEntry struct {
 
 type ScreenshotsPageData struct {
 	Title       string
-	Screenshots []ScreenshotEntry
+	Screenshots []ScreenshotMeta
+	Total       int64
+	PrevPage    int
+	NextPage    int
+	Sort        string
+	Order       string
+}
+
+type PreviewPageData struct {
+	Title   string
+	URL     string
+	APIURL  string
+	Image   template.URL
+	Width   int
+	Height  int
+	Size    int
+	Cached  bool
+	Timings []PreviewTiming
+}
+
+type PreviewTiming struct {
+	Phase string
+	Ms    int64
+}
+
+type ScreenshotsPage struct {
+	Data    []ScreenshotMeta `json:"data"`
+	Total   int64            `json:"total"`
+	Page    int              `json:"page"`
+	PerPage int              `json:"per_page"`
 }
 
 type Blocklist struct {
-	domains map[string]struct{}
-	mu      sync.RWMutex
-	logger  *slog.Logger
+	trie       *domainTrie
+	sorted     []string
+	added      map[string]struct{}
+	cidrs      []*net.IPNet
+	addedCIDRs []*net.IPNet
+	mu         sync.RWMutex
+	logger     *slog.Logger
+	checks     atomic.Int64
+	blocked    atomic.Int64
+	hits       map[string]int64
+	hitsMu     sync.Mutex
+}
+
+type BlocklistStats struct {
+	Checks     int64         `json:"checks"`
+	Blocked    int64         `json:"blocked"`
+	Allowed    int64         `json:"allowed"`
+	TopBlocked []DomainCount `json:"top_blocked"`
+}
+
+type BlockedResponse struct {
+	Domain  string `json:"domain"`
+	Blocked bool   `json:"blocked"`
+	Reason  string `json:"reason"`
+}
+
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int64  `json:"count"`
+}
+
+type ScreenshotStore interface {
+	Get(url string, width, height int, format, variant string) (CachedScreenshot, error)
+	GetWithTTL(url string, width, height int, format, variant string, maxAge time.Duration) (CachedScreenshot, error)
+	Save(url string, shot CachedScreenshot, width, height int, format, variant string) error
+	GetOrCreate(url string, width, height int, format, variant string, maxAge time.Duration, fn func() (CachedScreenshot, error)) (CachedScreenshot, bool, error)
+	List(offset, limit int, order ListOrder) ([]ScreenshotMeta, int64, error)
+	Latest(url string) ([]byte, string, time.Time, error)
+	CountByURLPrefix(prefix string) (int64, error)
+	PurgeByURLPrefix(prefix string) (int64, error)
+	Ping() error
+	Close() error
+}
+
+type ListOrder struct {
+	Sort string
+	Asc  bool
+}
+
+var listSortFields = []string{"url", "width", "height", "data_size", "created_at"}
+
+func (o ListOrder) orderBy() string {
+	var column string
+	switch o.Sort {
+	case "url":
+		column = "url"
+	case "width":
+		column = "width"
+	case "height":
+		column = "height"
+	case "data_size":
+		column = "length(data)"
+	case "created_at":
+		column = "created_at"
+	default:
+		column = "id"
+	}
+
+	dir := " DESC"
+	if o.Asc {
+		dir = " ASC"
+	}
+	if column == "id" {
+		return column + dir
+	}
+	return column + dir + ", id" + dir
+}
+
+func (o ListOrder) compare(a, b ScreenshotMeta) int {
+	var c int
+	switch o.Sort {
+	case "url":
+		c = strings.Compare(a.URL, b.URL)
+	case "width":
+		c = cmp.Compare(a.Width, b.Width)
+	case "height":
+		c = cmp.Compare(a.Height, b.Height)
+	case "data_size":
+		c = cmp.Compare(a.DataSize, b.DataSize)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.CreatedAt, b.CreatedAt)
+	}
+	if !o.Asc {
+		c = -c
+	}
+	return c
+}
+
+func parseListOrder(r *http.Request) (ListOrder, error) {
+	var order ListOrder
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		if !slices.Contains(listSortFields, sort) {
+			return order, fmt.Errorf("invalid sort %q: must be one of %s", sort, strings.Join(listSortFields, ", "))
+		}
+		order.Sort = sort
+	}
+
+	switch dir := r.URL.Query().Get("order"); dir {
+	case "", "desc":
+	case "asc":
+		order.Asc = true
+	default:
+		return order, fmt.Errorf("invalid order %q: must be asc or desc", dir)
+	}
+	return order, nil
 }
 
 type ScreenshotRepository struct {
-	db *sql.DB
+	db          *sql.DB
+	flight      singleflight.Group
+	maxEntries  int
+	evictBatch  int
+	stopSweeper func()
+
+	// entries is a running estimate of the row count, so Save only counts
+	// the table when the estimate reaches maxEntries. It never undercounts:
+	// replaced and deleted rows leave it high, and trim resyncs it.
+	entries atomic.Int64
+	counted atomic.Bool
+}
+
+type CachedScreenshot struct {
+	Data        []byte
+	ContentType string
+	Timing      Timing
+	CreatedAt   time.Time
+	TTL         time.Duration
+	Private     bool
+}
+
+type flightResult struct {
+	shot    CachedScreenshot
+	hit     bool
+	saveErr error
 }
 
 type Server struct {
-	browser   *rod.Browser
-	semaphore chan struct{}
-	config    Config
-	logger    *slog.Logger
-	blocklist *Blocklist
-	templates map[string]*template.Template
-	repo      *ScreenshotRepository
+	pool            *BrowserPool
+	breaker         *CircuitBreaker
+	perIP           *ipLimiter
+	robots          sync.Map
+	robotsClient    *http.Client
+	robotsSwept     atomic.Int64
+	semaphore       chan struct{}
+	config          Config
+	logger          *slog.Logger
+	blocklist       *Blocklist
+	templates       map[string]*template.Template
+	mux             *http.ServeMux
+	repo            *ScreenshotRepository
+	store           ScreenshotStore
+	startedAt       time.Time
+	blockedPatterns []*regexp.Regexp
+	watermark       image.Image
+	audits          sync.WaitGroup
+	inflight        sync.WaitGroup
+	closeOnce       sync.Once
+	closeErr        error
+	cacheHits       atomic.Int64
+	cacheMisses     atomic.Int64
+	totalCaptures   atomic.Int64
+	totalErrors     atomic.Int64
 }
 
 func DefaultConfig() Config {
-	port := os.Getenv("APP_PORT")
+	return configFrom(os.Getenv)
+}
+
+func configFrom(getenv func(string) string) Config {
+	port := getenv("APP_PORT")
 	if port == "" {
 		port = defaultPort
 	}
 
-	env := os.Getenv("APP_ENV")
+	env := getenv("APP_ENV")
 	if env == "" {
 		env = defaultEnv
 	}
 
-	password := os.Getenv("APP_PASSWORD")
+	password := getenv("APP_PASSWORD")
 	if password == "" {
 		password = defaultPassword
 	}
 
+	realm := getenv("APP_BASIC_AUTH_REALM")
+	if realm == "" {
+		realm = defaultBasicAuthRealm
+	}
+
+	acmeCacheDir := getenv("APP_ACME_CACHE_DIR")
+	if acmeCacheDir == "" {
+		acmeCacheDir = defaultACMECacheDir
+	}
+
+	redirectPort := getenv("APP_HTTP_REDIRECT_PORT")
+	if redirectPort == "" {
+		redirectPort = defaultRedirectPort
+	}
+
+	storage := getenv("APP_STORAGE_BACKEND")
+	if storage == "" {
+		storage = defaultStorage
+	}
+
+	sessionCookieTTL, _ := time.ParseDuration(getenv("APP_SESSION_COOKIE_TTL"))
+
+	browserHealthInterval := browserHealthCheckInterval
+	if v := getenv("APP_BROWSER_HEALTH_CHECK_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			browserHealthInterval = d
+		}
+	}
+
+	retries := captureRetries
+	if v := getenv("APP_CAPTURE_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			retries = n
+		}
+	}
+
+	retryDelay := captureRetryDelay
+	if v := getenv("APP_CAPTURE_RETRY_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			retryDelay = d
+		}
+	}
+
+	connLifetime := connMaxLifetime
+	if v := getenv("APP_DB_CONN_MAX_LIFETIME"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			connLifetime = d
+		}
+	}
+
+	apiVersions := envList(getenv, "APP_API_VERSIONS")
+	if len(apiVersions) == 0 {
+		apiVersions = []string{"v1"}
+	}
+
+	robotsUA := getenv("APP_ROBOTS_UA")
+	if robotsUA == "" {
+		robotsUA = defaultRobotsUA
+	}
+
 	return Config{
-		Port:            ":" + port,
-		PageTimeout:     pageTimeout,
-		ScreenshotQual:  screenshotQuality,
-		CacheTTLSecs:    cacheTTL,
-		MaxWidth:        maxWidth,
-		MaxHeight:       maxHeight,
-		MaxConcurrent:   maxConcurrent,
-		ShutdownTimeout: shutdownTimeout,
-		ReadTimeout:     readTimeout,
-		WriteTimeout:    writeTimeout,
-		IdleTimeout:     idleTimeout,
-		MinUserAgentLen: minUserAgentLen,
-		Debug:           env != "production",
-		BlockFonts:      true,
-		BlockMedia:      true,
-		Password:        password,
+		Port:                       ":" + port,
+		PageTimeout:                pageTimeout,
+		MaxPageTimeout:             maxPageTimeout,
+		ScreenshotQual:             screenshotQuality,
+		CacheTTLSecs:               envInt(getenv, "APP_CACHE_TTL_SECS", cacheTTL),
+		MaxTTLSecs:                 envInt(getenv, "APP_MAX_TTL_SECS", maxTTLSecs),
+		MaxWidth:                   maxWidth,
+		MaxHeight:                  maxHeight,
+		MaxConcurrent:              maxConcurrent,
+		MaxConcurrentPerIP:         envInt(getenv, "APP_MAX_CONCURRENT_PER_IP", maxConcurrentPerIP),
+		MaxCacheEntries:            envInt(getenv, "APP_MAX_CACHE_ENTRIES", maxCacheEntries),
+		EvictBatchSize:             envInt(getenv, "APP_EVICT_BATCH_SIZE", evictBatchSize),
+		DBMaxOpenConns:             envInt(getenv, "APP_DB_MAX_OPEN_CONNS", maxOpenConns),
+		DBMaxIdleConns:             envInt(getenv, "APP_DB_MAX_IDLE_CONNS", maxIdleDBConns),
+		DBConnMaxLifetime:          connLifetime,
+		MaxGoroutineWarnThreshold:  envInt(getenv, "APP_MAX_GOROUTINE_WARN_THRESHOLD", maxGoroutineWarnThreshold),
+		CaptureRetries:             retries,
+		CaptureRetryDelay:          retryDelay,
+		BrowserPoolSize:            envInt(getenv, "APP_BROWSER_POOL_SIZE", browserPoolSize),
+		BrowserHealthCheckInterval: browserHealthInterval,
+		CBFailureThreshold:         cbFailureThreshold,
+		CBRecoveryWindow:           cbRecoveryWindow,
+		WarmUpFile:                 getenv("APP_WARM_UP_FILE"),
+		SilenceHealthLogs:          getenv("APP_SILENCE_HEALTH_LOGS") == "true",
+		SessionCookieTTL:           sessionCookieTTL,
+		MaxScrollSteps:             maxScrollSteps,
+		BrowserUserAgent:           getenv("APP_BROWSER_USER_AGENT"),
+		BrowserProxy:               getenv("APP_BROWSER_PROXY"),
+		NoProxyHosts:               envList(getenv, "APP_NO_PROXY_HOSTS"),
+		AllowedHosts:               envList(getenv, "APP_ALLOWED_HOSTS"),
+		APIVersions:                apiVersions,
+		RespectRobotsTxt:           getenv("APP_RESPECT_ROBOTS_TXT") == "true",
+		RobotsFetchUA:              robotsUA,
+		OTELEndpoint:               getenv("APP_OTEL_ENDPOINT"),
+		RobotsTTL:                  robotsTTL,
+		MaxBatchSize:               maxBatchSize,
+		MaxCSSBytes:                maxCSSBytes,
+		AllowedCSSHosts:            envList(getenv, "APP_ALLOWED_CSS_HOSTS"),
+		HealthCheckTimeout:         healthCheckTimeout,
+		AllowedOrigins:             envList(getenv, "APP_ALLOWED_ORIGINS"),
+		BlockedURLPatterns:         envList(getenv, "APP_BLOCKED_URL_PATTERNS"),
+		AllowExtraHeaders:          getenv("APP_ALLOW_EXTRA_HEADERS") == "true",
+		AllowTargetAuth:            getenv("APP_ALLOW_TARGET_AUTH") == "true",
+		AllowBrowserInteraction:    getenv("APP_ALLOW_BROWSER_INTERACTION") == "true",
+		AllowDateMocking:           getenv("APP_ALLOW_DATE_MOCKING") == "true",
+		WatermarkPath:              getenv("APP_WATERMARK_PATH"),
+		AllowNoJS:                  getenv("APP_ALLOW_NO_JS") == "true",
+		StorageBackend:             storage,
+		RedisURL:                   getenv("APP_REDIS_URL"),
+		RedisTTL:                   redisTTL,
+		MaxURLLength:               maxURLLength,
+		TLSCertFile:                getenv("APP_TLS_CERT_FILE"),
+		TLSKeyFile:                 getenv("APP_TLS_KEY_FILE"),
+		TLSAutoACME:                getenv("APP_TLS_AUTO_ACME") == "true",
+		ACMEDomain:                 getenv("APP_ACME_DOMAIN"),
+		ACMECacheDir:               acmeCacheDir,
+		HTTPRedirectPort:           ":" + redirectPort,
+		TrustProxy:                 getenv("APP_TRUST_PROXY") == "true",
+		MaxPurgeRows:               maxPurgeRows,
+		AllowPrivateIPs:            getenv("APP_ALLOW_PRIVATE_IPS") == "true",
+		AllowQualityOverride:       getenv("APP_ALLOW_QUALITY_OVERRIDE") != "false",
+		ShutdownTimeout:            shutdownTimeout,
+		ReadTimeout:                readTimeout,
+		WriteTimeout:               writeTimeout,
+		IdleTimeout:                idleTimeout,
+		MinUserAgentLen:            minUserAgentLen,
+		Debug:                      env != "production",
+		EnablePprof:                getenv("APP_ENABLE_PPROF") == "true",
+		BlockFonts:                 getenv("APP_BLOCK_FONTS") != "false",
+		BlockMedia:                 getenv("APP_BLOCK_MEDIA") != "false",
+		AutoMigrate:                getenv("APP_AUTO_MIGRATE") != "false",
+		Password:                   password,
+		BasicAuthRealm:             realm,
+	}
+}
+
+func (c Config) TLSEnabled() bool {
+	return c.TLSAutoACME || (c.TLSCertFile != "" && c.TLSKeyFile != "")
+}
+
+func (c Config) View() ConfigView {
+	redact := func(v string) string {
+		if v == "" {
+			return ""
+		}
+		return "[REDACTED]"
+	}
+
+	return ConfigView{
+		Port:                       c.Port,
+		PageTimeout:                c.PageTimeout.String(),
+		MaxPageTimeout:             c.MaxPageTimeout.String(),
+		ScreenshotQual:             c.ScreenshotQual,
+		CacheTTLSecs:               c.CacheTTLSecs,
+		MaxTTLSecs:                 c.MaxTTLSecs,
+		MaxWidth:                   c.MaxWidth,
+		MaxHeight:                  c.MaxHeight,
+		MaxConcurrent:              c.MaxConcurrent,
+		MaxConcurrentPerIP:         c.MaxConcurrentPerIP,
+		MaxCacheEntries:            c.MaxCacheEntries,
+		EvictBatchSize:             c.EvictBatchSize,
+		DBMaxOpenConns:             c.DBMaxOpenConns,
+		DBMaxIdleConns:             c.DBMaxIdleConns,
+		DBConnMaxLifetime:          c.DBConnMaxLifetime.String(),
+		MaxGoroutineWarnThreshold:  c.MaxGoroutineWarnThreshold,
+		CaptureRetries:             c.CaptureRetries,
+		CaptureRetryDelay:          c.CaptureRetryDelay.String(),
+		BrowserPoolSize:            c.BrowserPoolSize,
+		BrowserHealthCheckInterval: c.BrowserHealthCheckInterval.String(),
+		CBFailureThreshold:         c.CBFailureThreshold,
+		CBRecoveryWindow:           c.CBRecoveryWindow.String(),
+		WarmUpFile:                 c.WarmUpFile,
+		SilenceHealthLogs:          c.SilenceHealthLogs,
+		SessionCookieTTL:           c.SessionCookieTTL.String(),
+		MaxScrollSteps:             c.MaxScrollSteps,
+		WarmUpURLs:                 c.WarmUpURLs,
+		BrowserUserAgent:           c.BrowserUserAgent,
+		BrowserProxy:               redact(c.BrowserProxy),
+		NoProxyHosts:               c.NoProxyHosts,
+		AllowedHosts:               c.AllowedHosts,
+		APIVersions:                c.APIVersions,
+		RespectRobotsTxt:           c.RespectRobotsTxt,
+		RobotsFetchUA:              c.RobotsFetchUA,
+		OTELEndpoint:               c.OTELEndpoint,
+		RobotsTTL:                  c.RobotsTTL.String(),
+		MaxBatchSize:               c.MaxBatchSize,
+		MaxCSSBytes:                c.MaxCSSBytes,
+		AllowedCSSHosts:            c.AllowedCSSHosts,
+		HealthCheckTimeout:         c.HealthCheckTimeout.String(),
+		AllowedOrigins:             c.AllowedOrigins,
+		AllowQualityOverride:       c.AllowQualityOverride,
+		BlockedURLPatterns:         c.BlockedURLPatterns,
+		AllowExtraHeaders:          c.AllowExtraHeaders,
+		AllowTargetAuth:            c.AllowTargetAuth,
+		AllowBrowserInteraction:    c.AllowBrowserInteraction,
+		AllowDateMocking:           c.AllowDateMocking,
+		WatermarkPath:              c.WatermarkPath,
+		AllowNoJS:                  c.AllowNoJS,
+		StorageBackend:             c.StorageBackend,
+		RedisURL:                   redact(c.RedisURL),
+		RedisTTL:                   c.RedisTTL.String(),
+		MaxURLLength:               c.MaxURLLength,
+		TLSCertFile:                c.TLSCertFile,
+		TLSKeyFile:                 c.TLSKeyFile,
+		TLSAutoACME:                c.TLSAutoACME,
+		ACMEDomain:                 c.ACMEDomain,
+		ACMECacheDir:               c.ACMECacheDir,
+		HTTPRedirectPort:           c.HTTPRedirectPort,
+		TrustProxy:                 c.TrustProxy,
+		MaxPurgeRows:               c.MaxPurgeRows,
+		AllowPrivateIPs:            c.AllowPrivateIPs,
+		ShutdownTimeout:            c.ShutdownTimeout.String(),
+		ReadTimeout:                c.ReadTimeout.String(),
+		WriteTimeout:               c.WriteTimeout.String(),
+		IdleTimeout:                c.IdleTimeout.String(),
+		MinUserAgentLen:            c.MinUserAgentLen,
+		Debug:                      c.Debug,
+		EnablePprof:                c.EnablePprof,
+		BlockFonts:                 c.BlockFonts,
+		BlockMedia:                 c.BlockMedia,
+		AutoMigrate:                c.AutoMigrate,
+		Password:                   redact(c.Password),
+		BasicAuthRealm:             c.BasicAuthRealm,
+	}
+}
+
+func envList(getenv func(string) string, key string) []string {
+	var values []string
+	for _, v := range strings.Split(getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func envInt(getenv func(string) string, key string, fallback int) int {
+	n, err := strconv.Atoi(getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
 	}
+	return n
 }
 
 func NewScreenshotRepository(dbPath string) (*ScreenshotRepository, error) {
+	return NewScreenshotRepositoryWithConfig(dbPath, Config{
+		DBMaxOpenConns:    maxOpenConns,
+		DBMaxIdleConns:    maxIdleDBConns,
+		DBConnMaxLifetime: connMaxLifetime,
+	})
+}
+
+func NewScreenshotRepositoryWithConfig(dbPath string, cfg Config) (*ScreenshotRepository, error) {
+	repo, err := OpenScreenshotRepository(dbPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.MigrateUp(); err != nil {
+		repo.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return repo, nil
+}
+
+// OpenScreenshotRepository opens the database without migrating it, for
+// callers that apply migrations as a separate step.
+func OpenScreenshotRepository(dbPath string, cfg Config) (*ScreenshotRepository, error) {
 	path := strings.Split(dbPath, "?")[0]
 	dir := filepath.Dir(path)
 
@@ -219,9 +1064,9 @@ func NewScreenshotRepository(dbPath string) (*ScreenshotRepository, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	db.SetMaxOpenConns(maxOpenConns)
-	db.SetMaxIdleConns(maxIdleDBConns)
-	db.SetConnMaxLifetime(connMaxLifetime)
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(min(cfg.DBMaxIdleConns, cfg.DBMaxOpenConns))
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
 
 	if err := db.Ping(); err != nil {
 		db.Close()
@@ -233,591 +1078,3713 @@ func NewScreenshotRepository(dbPath string) (*ScreenshotRepository, error) {
 		return nil, fmt.Errorf("failed to apply pragmas: %w", err)
 	}
 
-	if err := runMigrations(db); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	return &ScreenshotRepository{db: db}, nil
+}
+
+func (r *ScreenshotRepository) Get(url string, width, height int, format, variant string) (CachedScreenshot, error) {
+	return r.GetWithTTL(url, width, height, format, variant, 0)
+}
+
+// GetWithTTL treats entries older than maxAge as missing, unless they were
+// saved with their own ttl. A zero maxAge disables the check.
+func (r *ScreenshotRepository) GetWithTTL(url string, width, height int, format, variant string, maxAge time.Duration) (CachedScreenshot, error) {
+	var shot CachedScreenshot
+	var timingJSON sql.NullString
+	var createdAt sql.NullTime
+	var ttlSecs sql.NullInt64
+
+	key := cacheKeyFor(url, width, height, format, variant)
+	query := `SELECT data, content_type, timing_json, created_at, ttl_secs FROM screenshots WHERE cache_key = ?`
+	args := []any{key}
+	if maxAge > 0 {
+		query += ` AND (ttl_secs IS NOT NULL OR created_at > datetime('now', ?))`
+		args = append(args, fmt.Sprintf("-%d seconds", max(int64(maxAge.Seconds()), 1)))
+	}
+	err := r.db.QueryRow(query, args...).Scan(&shot.Data, &shot.ContentType, &timingJSON, &createdAt, &ttlSecs)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return shot, ErrNotFound
+		}
+		return shot, fmt.Errorf("failed to get screenshot: %w", err)
 	}
 
-	return &ScreenshotRepository{db: db}, nil
+	shot.TTL = time.Duration(ttlSecs.Int64) * time.Second
+	if shot.TTL > 0 && createdAt.Valid && time.Since(createdAt.Time) > shot.TTL {
+		return CachedScreenshot{}, ErrNotFound
+	}
+
+	if timingJSON.Valid {
+		if err := json.Unmarshal([]byte(timingJSON.String), &shot.Timing); err != nil {
+			return shot, fmt.Errorf("failed to parse timing: %w", err)
+		}
+	}
+	shot.CreatedAt = createdAt.Time
+
+	return shot, nil
 }
 
-func (r *ScreenshotRepository) Get(url string, width, height int) ([]byte, string, error) {
+func (r *ScreenshotRepository) Latest(url string) ([]byte, string, time.Time, error) {
 	var data []byte
 	var contentType string
+	var createdAt sql.NullTime
 
-	query := `SELECT data, content_type FROM screenshots WHERE url = ? AND width = ? AND height = ?`
-	err := r.db.QueryRow(query, url, width, height).Scan(&data, &contentType)
+	query := `SELECT data, content_type, created_at FROM screenshots WHERE url = ? ORDER BY id DESC LIMIT 1`
+	err := r.db.QueryRow(query, url).Scan(&data, &contentType, &createdAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, "", ErrNotFound
+			return nil, "", time.Time{}, ErrNotFound
 		}
-		return nil, "", fmt.Errorf("failed to get screenshot: %w", err)
+		return nil, "", time.Time{}, fmt.Errorf("failed to get latest screenshot: %w", err)
 	}
 
-	return data, contentType, nil
+	return data, contentType, createdAt.Time, nil
 }
 
-func (r *ScreenshotRepository) Save(url string, data []byte, contentType string, width, height int) error {
-	query := `INSERT OR REPLACE INTO screenshots (url, data, content_type, width, height) VALUES (?, ?, ?, ?, ?)`
-	_, err := r.db.Exec(query, url, data, contentType, width, height)
+func (r *ScreenshotRepository) Save(url string, shot CachedScreenshot, width, height int, format, variant string) error {
+	timingJSON, err := json.Marshal(shot.Timing)
+	if err != nil {
+		return fmt.Errorf("failed to encode timing: %w", err)
+	}
+
+	if r.maxEntries > 0 && (!r.counted.Load() || r.entries.Load() >= int64(r.maxEntries)) {
+		if err := r.trim(); err != nil {
+			return err
+		}
+	}
+
+	key := cacheKeyFor(url, width, height, format, variant)
+	var ttlSecs sql.NullInt64
+	if shot.TTL > 0 {
+		ttlSecs = sql.NullInt64{Int64: int64(shot.TTL.Seconds()), Valid: true}
+	}
+
+	createdAt := shot.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	query := `INSERT OR REPLACE INTO screenshots (cache_key, url, variant, format, data, content_type, width, height, timing_json, ttl_secs, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = r.db.Exec(query, key, url, variant, format, shot.Data, shot.ContentType, width, height, string(timingJSON), ttlSecs, createdAt.UTC().Format(time.DateTime))
 	if err != nil {
 		return fmt.Errorf("failed to save screenshot: %w", err)
 	}
+	r.entries.Add(1)
 	return nil
 }
 
-func (r *ScreenshotRepository) List() (string, error) {
-	query := `
-		SELECT json_group_array(
-			json_object(
-				'id', id,
-				'url', url,
-				'data_size', length(data),
-				'content_type', content_type,
-				'width', width,
-				'height', height,
-				'created_at', created_at
-			)
-		)
-		FROM screenshots
-		ORDER BY id DESC
-	`
+func (r *ScreenshotRepository) Count() (int64, error) {
+	var count int64
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM screenshots`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count screenshots: %w", err)
+	}
+	return count, nil
+}
 
-	rows, err := r.db.Query(query)
+func (r *ScreenshotRepository) DeleteExpired() (int64, error) {
+	query := `DELETE FROM screenshots WHERE ttl_secs IS NOT NULL AND created_at < datetime('now', '-' || ttl_secs || ' seconds')`
+	res, err := r.db.Exec(query)
 	if err != nil {
-		return "", fmt.Errorf("failed to list screenshots: %w", err)
+		return 0, fmt.Errorf("failed to delete expired screenshots: %w", err)
 	}
-	defer rows.Close()
+	return res.RowsAffected()
+}
+
+// Sweep deletes expired screenshots and trims the cache back under
+// maxEntries.
+func (r *ScreenshotRepository) Sweep() error {
+	if _, err := r.DeleteExpired(); err != nil {
+		return err
+	}
+	if r.maxEntries <= 0 {
+		return nil
+	}
+	return r.trim()
+}
 
-	var jsonResult string
-	if rows.Next() {
-		if err := rows.Scan(&jsonResult); err != nil {
-			return "", fmt.Errorf("failed to scan result: %w", err)
+// trim counts the cache and, once it holds maxEntries or more, evicts the
+// oldest down to evictBatch below the limit.
+func (r *ScreenshotRepository) trim() error {
+	count, err := r.Count()
+	if err != nil {
+		return err
+	}
+	if count >= int64(r.maxEntries) {
+		n := int(count) - r.maxEntries + max(r.evictBatch, 1)
+		if err := r.evictOldest(n); err != nil {
+			return err
 		}
+		count -= int64(n)
 	}
+	r.entries.Store(count)
+	r.counted.Store(true)
+	return nil
+}
+
+// StartSweeper runs Sweep every interval until the repository is closed, so
+// expired screenshots are removed even when nothing new is saved.
+func (r *ScreenshotRepository) StartSweeper(interval time.Duration, logger *slog.Logger) {
+	done := make(chan struct{})
+	r.stopSweeper = sync.OnceFunc(func() { close(done) })
 
-	return jsonResult, nil
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := r.Sweep(); err != nil {
+					logger.Warn("cache sweep failed", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
 }
 
-func (r *ScreenshotRepository) Ping() error {
-	return r.db.Ping()
+func (r *ScreenshotRepository) evictOldest(n int) error {
+	query := `DELETE FROM screenshots WHERE id IN (SELECT id FROM screenshots ORDER BY id ASC LIMIT ?)`
+	if _, err := r.db.Exec(query, n); err != nil {
+		return fmt.Errorf("failed to evict screenshots: %w", err)
+	}
+	return nil
 }
 
-func (r *ScreenshotRepository) Close() error {
-	return r.db.Close()
+func (r *ScreenshotRepository) GetOrCreate(url string, width, height int, format, variant string, maxAge time.Duration, fn func() (CachedScreenshot, error)) (CachedScreenshot, bool, error) {
+	return getOrCreate(r, &r.flight, url, width, height, format, variant, maxAge, fn)
 }
 
-func NewBlocklist(logger *slog.Logger) (*Blocklist, error) {
-	bl := &Blocklist{
-		domains: make(map[string]struct{}),
-		logger:  logger,
+func getOrCreate(store ScreenshotStore, flight *singleflight.Group, url string, width, height int, format, variant string, maxAge time.Duration, fn func() (CachedScreenshot, error)) (CachedScreenshot, bool, error) {
+	if shot, err := store.GetWithTTL(url, width, height, format, variant, maxAge); err == nil {
+		return shot, true, nil
 	}
 
-	for _, d := range criticalDomains {
-		bl.domains[d] = struct{}{}
-	}
+	key := cacheKeyFor(url, width, height, format, variant)
+	v, err, _ := flight.Do(key, func() (any, error) {
+		if shot, err := store.GetWithTTL(url, width, height, format, variant, maxAge); err == nil {
+			return flightResult{shot: shot, hit: true}, nil
+		}
 
-	data, err := assets.EmbeddedFiles.ReadFile("filters/domains.json")
+		shot, err := fn()
+		if err != nil {
+			return nil, err
+		}
+
+		result := flightResult{shot: shot}
+		if err := store.Save(url, shot, width, height, format, variant); err != nil {
+			result.saveErr = fmt.Errorf("%w: %w", ErrCacheWrite, err)
+		}
+		return result, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("reading domains.json: %w", err)
+		return CachedScreenshot{}, false, err
 	}
 
-	var domainList []string
-	if err := json.Unmarshal(data, &domainList); err != nil {
-		return nil, fmt.Errorf("parsing domains.json: %w", err)
-	}
+	result := v.(flightResult)
+	return result.shot, result.hit, result.saveErr
+}
 
-	for _, d := range domainList {
-		bl.domains[d] = struct{}{}
+func (r *ScreenshotRepository) List(offset, limit int, order ListOrder) ([]ScreenshotMeta, int64, error) {
+	total, err := r.Count()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	logger.Info("blocklist loaded", slog.Int("domains", len(bl.domains)))
-	return bl, nil
-}
-
-func (bl *Blocklist) IsBlocked(host string) bool {
-	bl.mu.RLock()
-	defer bl.mu.RUnlock()
+	query := `
+		SELECT id, url, length(data), content_type, width, height, created_at
+		FROM screenshots
+		ORDER BY ` + order.orderBy() + `
+		LIMIT ? OFFSET ?
+	`
 
-	if _, ok := bl.domains[host]; ok {
-		return true
+	rows, err := r.db.Query(query, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list screenshots: %w", err)
 	}
+	defer rows.Close()
 
-	parts := strings.Split(host, ".")
-	for i := 1; i < len(parts)-1; i++ {
-		parent := strings.Join(parts[i:], ".")
-		if _, ok := bl.domains[parent]; ok {
-			return true
+	entries := []ScreenshotMeta{}
+	for rows.Next() {
+		var entry ScreenshotMeta
+		var createdAt sql.NullTime
+		if err := rows.Scan(&entry.ID, &entry.URL, &entry.DataSize, &entry.ContentType, &entry.Width, &entry.Height, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan screenshot: %w", err)
 		}
+		entry.CreatedAt = createdAt.Time.Format(time.DateTime)
+		entries = append(entries, entry)
 	}
 
-	return false
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list screenshots: %w", err)
+	}
+
+	return entries, total, nil
 }
 
-func NewServer(cfg Config, logger *slog.Logger, repo *ScreenshotRepository) (*Server, error) {
-	blocklist, err := NewBlocklist(logger)
+func (r *ScreenshotRepository) RecordCapture(rec CaptureRecord) error {
+	query := `INSERT INTO captures (url, width, height, format, remote_ip, user_agent, status_code, duration_ms, cache_hit) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := r.db.Exec(query, rec.URL, rec.Width, rec.Height, rec.Format, rec.RemoteIP, rec.UserAgent, rec.StatusCode, rec.DurationMs, rec.CacheHit)
 	if err != nil {
-		logger.Warn("failed to initialize blocklist", slog.String("error", err.Error()))
-		blocklist = &Blocklist{domains: make(map[string]struct{}), logger: logger}
+		return fmt.Errorf("failed to record capture: %w", err)
 	}
+	return nil
+}
 
-	templates, err := parseTemplates()
+func (r *ScreenshotRepository) ListCaptures(since time.Time, limit, offset int) ([]CaptureRecord, error) {
+	query := `
+		SELECT id, url, width, height, format, remote_ip, user_agent, status_code, duration_ms, cache_hit, created_at
+		FROM captures
+		WHERE created_at >= ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.Query(query, since.UTC().Format(time.DateTime), limit, offset)
 	if err != nil {
-		return nil, fmt.Errorf("parsing templates: %w", err)
+		return nil, fmt.Errorf("failed to list captures: %w", err)
 	}
+	defer rows.Close()
 
-	path, found := launcher.LookPath()
-	if !found {
-		return nil, ErrBrowserMissing
+	records := []CaptureRecord{}
+	for rows.Next() {
+		var rec CaptureRecord
+		if err := rows.Scan(&rec.ID, &rec.URL, &rec.Width, &rec.Height, &rec.Format, &rec.RemoteIP, &rec.UserAgent, &rec.StatusCode, &rec.DurationMs, &rec.CacheHit, &rec.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan capture: %w", err)
+		}
+		records = append(records, rec)
 	}
 
-	url := launcher.New().
-		Bin(path).
-		Headless(true).
-		Set("no-sandbox").
-		Set("disable-gpu").
-		Set("disable-dev-shm-usage").
-		Set("disable-extensions").
-		Set("disable-plugins").
-		Set("disable-background-networking").
-		Set("disable-background-timer-throttling").
-		Set("disable-backgrounding-occluded-windows").
-		Set("disable-renderer-backgrounding").
-		Set("disable-sync").
-		Set("disable-translate").
-		Set("disable-default-apps").
-		Set("no-first-run").
-		Set("hide-scrollbars").
-		Set("mute-audio").
-		MustLaunch()
-
-	browser := rod.New().ControlURL(url)
-	if err := browser.Connect(); err != nil {
-		return nil, fmt.Errorf("connecting to browser: %w", err)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list captures: %w", err)
 	}
 
-	return &Server{
-		browser:   browser,
-		semaphore: make(chan struct{}, cfg.MaxConcurrent),
-		config:    cfg,
-		logger:    logger,
-		blocklist: blocklist,
-		templates: templates,
-		repo:      repo,
-	}, nil
+	return records, nil
 }
 
-func (s *Server) Close() error {
-	if s.repo != nil {
-		s.repo.Close()
+func (r *ScreenshotRepository) CountByURLPrefix(prefix string) (int64, error) {
+	var count int64
+	query := `SELECT COUNT(*) FROM screenshots WHERE url LIKE ? ESCAPE '\'`
+	if err := r.db.QueryRow(query, likePrefix(prefix)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count screenshots: %w", err)
 	}
-	return s.browser.Close()
+	return count, nil
 }
 
-func (s *Server) ServeHTTP(mux *http.ServeMux) {
-	mux.Handle("GET /static/", http.FileServer(http.FS(assets.EmbeddedFiles)))
-	mux.HandleFunc("GET /robots.txt", s.handleRobots)
-	mux.HandleFunc("GET /healthz", s.handleHealth)
-	mux.HandleFunc("GET /favicon.ico", s.handleFavicon)
-	mux.HandleFunc("GET /site.webmanifest", s.handleWebManifest)
-	mux.HandleFunc("GET /blocked", s.handleBlocked)
-	mux.HandleFunc("GET /domains.json", s.basicAuth(s.handleDomains))
-	mux.HandleFunc("GET /screenshots", s.basicAuth(s.handleScreenshots))
-	mux.HandleFunc("GET /{$}", s.handleScreenshot)
-	mux.HandleFunc("/", s.handleNotFound)
+func (r *ScreenshotRepository) PurgeByURLPrefix(prefix string) (int64, error) {
+	query := `DELETE FROM screenshots WHERE url LIKE ? ESCAPE '\'`
+	res, err := r.db.Exec(query, likePrefix(prefix))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge screenshots: %w", err)
+	}
+	return res.RowsAffected()
 }
 
-func (s *Server) handleNotFound(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusNotFound)
-	s.templates["404"].Execute(w, PageData{Title: "404 - Not Found"})
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(prefix) + "%"
 }
 
-func (s *Server) handleIndex(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	s.templates["index"].Execute(w, PageData{Title: "Screenshot"})
-}
+func (r *ScreenshotRepository) GetStats() (RepositoryStats, error) {
+	stats := RepositoryStats{BytesByFormat: make(map[string]int64)}
 
-func (s *Server) handleError(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(code)
-	s.templates["error"].Execute(w, PageData{
-		Title:   fmt.Sprintf("%d - Error", code),
-		Code:    code,
-		Message: message,
-	})
-}
+	query := `
+		SELECT content_type, COUNT(*), COALESCE(SUM(length(data)), 0), MIN(created_at), MAX(created_at)
+		FROM screenshots
+		GROUP BY content_type
+	`
 
-func (s *Server) handleRobots(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get stats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contentType string
+		var count, bytes int64
+		var oldest, newest sql.NullString
+		if err := rows.Scan(&contentType, &count, &bytes, &oldest, &newest); err != nil {
+			return stats, fmt.Errorf("failed to scan stats: %w", err)
+		}
+
+		stats.TotalRows += count
+		stats.TotalBytes += bytes
+		stats.BytesByFormat[contentType] += bytes
+
+		if t, ok := parseDBTime(oldest); ok && (stats.Oldest == nil || t.Before(*stats.Oldest)) {
+			stats.Oldest = &t
+		}
+		if t, ok := parseDBTime(newest); ok && (stats.Newest == nil || t.After(*stats.Newest)) {
+			stats.Newest = &t
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	return stats, nil
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	if s.repo != nil {
-		if err := s.repo.Ping(); err != nil {
-			http.Error(w, "database connection failed", http.StatusServiceUnavailable)
-			return
+func parseDBTime(s sql.NullString) (time.Time, bool) {
+	if !s.Valid {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.DateTime, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s.String); err == nil {
+			return t, true
 		}
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Write([]byte("ok"))
+	return time.Time{}, false
 }
 
-func (s *Server) handleFavicon(w http.ResponseWriter, _ *http.Request) {
-	data, err := assets.EmbeddedFiles.ReadFile("static/favicon.ico")
+func (r *ScreenshotRepository) SchemaVersion() (int, error) {
+	if err := setupGoose(); err != nil {
+		return 0, err
+	}
+
+	version, err := goose.GetDBVersion(r.db)
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		return
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
 	}
-	w.Header().Set("Content-Type", "image/x-icon")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
-	w.Write(data)
+
+	return int(version), nil
 }
 
-func (s *Server) handleWebManifest(w http.ResponseWriter, _ *http.Request) {
-	data, err := assets.EmbeddedFiles.ReadFile("static/site.webmanifest")
+// LatestSchemaVersion returns the version of the newest embedded migration.
+func LatestSchemaVersion() (int, error) {
+	if err := setupGoose(); err != nil {
+		return 0, err
+	}
+
+	migrations, err := goose.CollectMigrations("migrations", 0, goose.MaxVersion)
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		return
+		return 0, fmt.Errorf("failed to collect migrations: %w", err)
 	}
-	w.Header().Set("Content-Type", "application/manifest+json")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
-	w.Write(data)
+	last, err := migrations.Last()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find latest migration: %w", err)
+	}
+
+	return int(last.Version), nil
 }
 
-func (s *Server) handleBlocked(w http.ResponseWriter, r *http.Request) {
-	domain := r.URL.Query().Get("domain")
-	if domain == "" {
-		http.Error(w, "missing domain parameter", http.StatusBadRequest)
-		return
+func (r *ScreenshotRepository) MigrateUp() error {
+	return runMigrations(r.db)
+}
+
+func (r *ScreenshotRepository) MigrateDown(n int) error {
+	if err := setupGoose(); err != nil {
+		return err
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	if s.blocklist.IsBlocked(domain) {
-		w.Write([]byte("blocked"))
-	} else {
-		w.Write([]byte("allowed"))
+	for range n {
+		if err := goose.Down(r.db, "migrations"); err != nil {
+			return fmt.Errorf("failed to roll back migration: %w", err)
+		}
 	}
+
+	return nil
 }
 
-func (s *Server) handleDomains(w http.ResponseWriter, _ *http.Request) {
-	data, err := assets.EmbeddedFiles.ReadFile("filters/domains.json")
+func (r *ScreenshotRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *ScreenshotRepository) IntegrityCheck() error {
+	return quickCheck(r.db)
+}
+
+func (r *ScreenshotRepository) Export(w io.Writer) error {
+	tmp, err := os.CreateTemp("", "screenshot-backup-*.sqlite")
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		return
+		return fmt.Errorf("failed to create backup file: %w", err)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
-	w.Write(data)
-}
+	path := tmp.Name()
+	tmp.Close()
+	defer os.Remove(path)
 
-func (s *Server) handleScreenshots(w http.ResponseWriter, r *http.Request) {
-	if s.repo == nil {
-		http.Error(w, "database not configured", http.StatusServiceUnavailable)
-		return
+	if err := r.backupTo(path); err != nil {
+		return err
 	}
 
-	jsonResult, err := s.repo.List()
+	f, err := os.Open(path)
 	if err != nil {
-		s.logger.Error("failed to list screenshots", slog.String("error", err.Error()))
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to open backup file: %w", err)
 	}
+	defer f.Close()
 
-	if r.URL.Query().Get("format") == "json" {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", screenshotsCacheTTL))
-		w.Write([]byte(jsonResult))
-		return
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
 	}
+	return nil
+}
 
-	var screenshots []ScreenshotEntry
-	if err := json.Unmarshal([]byte(jsonResult), &screenshots); err != nil {
-		s.logger.Error("failed to parse screenshots", slog.String("error", err.Error()))
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
+func (r *ScreenshotRepository) backupTo(path string) error {
+	ctx := context.Background()
+
+	dstDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup database: %w", err)
 	}
+	defer dstDB.Close()
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", screenshotsCacheTTL))
-	s.templates["screenshots"].Execute(w, ScreenshotsPageData{
-		Title:       "Screenshots",
-		Screenshots: screenshots,
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to backup database: %w", err)
+	}
+	defer dstConn.Close()
+
+	srcConn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dst any) error {
+		return srcConn.Raw(func(src any) error {
+			dstSQLite, ok := dst.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup destination is not a sqlite connection")
+			}
+			srcSQLite, ok := src.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup source is not a sqlite connection")
+			}
+
+			backup, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
 	})
 }
 
-func (s *Server) handleScreenshot(w http.ResponseWriter, r *http.Request) {
-	userAgent := r.Header.Get("User-Agent")
-	if s.isBot(userAgent) {
-		s.logger.Warn("blocked bot request", slog.String("ua", userAgent), slog.String("ip", r.RemoteAddr))
-		s.handleError(w, http.StatusForbidden, "Forbidden")
-		return
+func (r *ScreenshotRepository) Close() error {
+	if r.stopSweeper != nil {
+		r.stopSweeper()
 	}
+	return r.db.Close()
+}
 
-	targetURL := r.URL.Query().Get("url")
-	if targetURL == "" {
-		s.handleIndex(w, r)
-		return
+func NewBlocklist(logger *slog.Logger) (*Blocklist, error) {
+	trie, sorted, err := loadBlocklistDomains()
+	if err != nil {
+		return nil, err
 	}
 
-	if !strings.HasPrefix(targetURL, "http://") && !strings.HasPrefix(targetURL, "https://") {
-		targetURL = "https://" + targetURL
+	cidrs, err := loadBlocklistCIDRs()
+	if err != nil {
+		return nil, err
 	}
 
-	width, height := s.parseDimensions(r)
-	fullPage := r.URL.Query().Get("full") == "true"
+	bl := &Blocklist{
+		trie:   trie,
+		sorted: sorted,
+		added:  make(map[string]struct{}),
+		cidrs:  cidrs,
+		logger: logger,
+	}
 
-	etag := generateETag(targetURL, width, height)
-	if r.Header.Get("If-None-Match") == etag {
-		w.WriteHeader(http.StatusNotModified)
-		return
+	logger.Info("blocklist loaded", slog.Int("domains", trie.size), slog.Int("cidrs", len(cidrs)))
+	return bl, nil
+}
+
+func loadBlocklistCIDRs() ([]*net.IPNet, error) {
+	data, err := assets.EmbeddedFiles.ReadFile("filters/ip_ranges.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading ip_ranges.json: %w", err)
 	}
 
-	if s.repo != nil && !fullPage {
-		if data, contentType, err := s.repo.Get(targetURL, width, height); err == nil {
-			s.logger.Info("screenshot served from cache",
-				slog.String("url", targetURL),
-				slog.Int("width", width),
-				slog.Int("height", height),
-			)
-			s.writeCachedResponse(w, data, contentType, etag)
-			return
+	var ranges []string
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, fmt.Errorf("parsing ip_ranges.json: %w", err)
+	}
+
+	cidrs := make([]*net.IPNet, 0, len(ranges))
+	for _, r := range ranges {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("parsing ip_ranges.json: %w", err)
 		}
+		cidrs = append(cidrs, ipNet)
 	}
+	return cidrs, nil
+}
 
-	select {
-	case s.semaphore <- struct{}{}:
-		defer func() { <-s.semaphore }()
-	case <-r.Context().Done():
-		s.handleError(w, http.StatusServiceUnavailable, "Request cancelled")
-		return
+func loadBlocklistDomains() (*domainTrie, []string, error) {
+	trie := newDomainTrie()
+
+	for _, d := range criticalDomains {
+		trie.Insert(d)
 	}
 
-	screenshot, timing, err := s.capture(targetURL, width, height, fullPage)
+	data, err := assets.EmbeddedFiles.ReadFile("filters/domains.json")
 	if err != nil {
-		s.handleCaptureError(w, targetURL, err, timing)
-		return
+		return nil, nil, fmt.Errorf("reading domains.json: %w", err)
 	}
 
-	if s.repo != nil && !fullPage {
-		if err := s.repo.Save(targetURL, screenshot, "image/webp", width, height); err != nil {
-			s.logger.Warn("failed to cache screenshot", slog.String("url", targetURL), slog.String("error", err.Error()))
-		}
+	var domainList []string
+	if err := json.Unmarshal(data, &domainList); err != nil {
+		return nil, nil, fmt.Errorf("parsing domains.json: %w", err)
 	}
 
-	s.logger.Info("screenshot captured",
-		slog.String("url", targetURL),
-		slog.Int64("setup_ms", timing.Setup.Milliseconds()),
-		slog.Int64("nav_ms", timing.Navigation.Milliseconds()),
-		slog.Int64("load_ms", timing.Load.Milliseconds()),
-		slog.Int64("screenshot_ms", timing.Screenshot.Milliseconds()),
-		slog.Int64("total_ms", timing.Total.Milliseconds()),
-		slog.Int("size_kb", len(screenshot)/1024),
-	)
+	for _, d := range domainList {
+		trie.Insert(d)
+	}
+	slices.Sort(domainList)
 
-	s.writeResponse(w, screenshot, etag, timing)
+	return trie, domainList, nil
 }
 
-func (s *Server) parseDimensions(r *http.Request) (int, int) {
-	dim := presets["thumb"]
-	if preset := r.URL.Query().Get("preset"); preset != "" {
-		if p, ok := presets[preset]; ok {
-			dim = p
-		}
+func (bl *Blocklist) WithPrefix(prefix string) []string {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+
+	start, _ := slices.BinarySearch(bl.sorted, prefix)
+	end := start
+	for end < len(bl.sorted) && strings.HasPrefix(bl.sorted[end], prefix) {
+		end++
 	}
+	return append([]string{}, bl.sorted[start:end]...)
+}
 
-	width, height := dim.Width, dim.Height
+func (bl *Blocklist) Add(domain string) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return
+	}
 
-	if r.URL.Query().Get("width") != "" {
-		width = parseIntParam(r, "width", width, s.config.MaxWidth)
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if bl.trie == nil {
+		bl.trie = newDomainTrie()
 	}
-	if r.URL.Query().Get("height") != "" {
-		height = parseIntParam(r, "height", height, s.config.MaxHeight)
+	if bl.added == nil {
+		bl.added = make(map[string]struct{})
 	}
 
-	return width, height
+	bl.trie.Insert(domain)
+	bl.added[domain] = struct{}{}
 }
 
-func (s *Server) capture(url string, width, height int, fullPage bool) ([]byte, Timing, error) {
-	var timing Timing
-	totalStart := time.Now()
+func (bl *Blocklist) AddCIDR(cidr string) error {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return fmt.Errorf("invalid cidr %q: %w", cidr, err)
+	}
 
-	setupStart := time.Now()
-	page, err := s.browser.Page(proto.TargetCreateTarget{})
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	bl.cidrs = append(bl.cidrs, ipNet)
+	bl.addedCIDRs = append(bl.addedCIDRs, ipNet)
+	return nil
+}
+
+func (bl *Blocklist) Reload() error {
+	trie, sorted, err := loadBlocklistDomains()
 	if err != nil {
-		return nil, timing, fmt.Errorf("creating page: %w", err)
+		return err
 	}
-	defer page.Close()
 
-	if err := page.SetViewport(&proto.EmulationSetDeviceMetricsOverride{
-		Width:             width,
-		Height:            height,
-		DeviceScaleFactor: 1.0,
-	}); err != nil {
-		return nil, timing, fmt.Errorf("setting viewport: %w", err)
+	cidrs, err := loadBlocklistCIDRs()
+	if err != nil {
+		return err
 	}
 
-	router := page.HijackRequests()
-	router.MustAdd("*", s.createRequestHandler())
-	go router.Run()
-	defer router.MustStop()
-	timing.Setup = time.Since(setupStart)
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
 
-	navStart := time.Now()
-	if err := page.Timeout(s.config.PageTimeout).Navigate(url); err != nil {
-		timing.Navigation = time.Since(navStart)
-		return nil, timing, fmt.Errorf("navigation timeout: %w", err)
+	for d := range bl.added {
+		trie.Insert(d)
 	}
-	timing.Navigation = time.Since(navStart)
+	bl.cidrs = append(cidrs, bl.addedCIDRs...)
 
-	loadStart := time.Now()
-	if err := page.Timeout(s.config.PageTimeout).WaitLoad(); err != nil {
-		timing.Load = time.Since(loadStart)
-		return nil, timing, fmt.Errorf("load timeout: %w", err)
+	oldCount := 0
+	if bl.trie != nil {
+		oldCount = bl.trie.size
 	}
-	timing.Load = time.Since(loadStart)
+	bl.trie = trie
+	bl.sorted = sorted
 
-	screenshotStart := time.Now()
-	quality := s.config.ScreenshotQual
-	screenshot, err := page.Screenshot(fullPage, &proto.PageCaptureScreenshot{
-		Format:           proto.PageCaptureScreenshotFormatWebp,
-		Quality:          &quality,
-		OptimizeForSpeed: true,
-	})
-	timing.Screenshot = time.Since(screenshotStart)
-	timing.Total = time.Since(totalStart)
+	bl.logger.Info("blocklist reloaded",
+		slog.Int("old_domains", oldCount),
+		slog.Int("new_domains", trie.size),
+	)
+	return nil
+}
 
+func (bl *Blocklist) ExportJSON() ([]byte, error) {
+	bl.mu.RLock()
+	var domains []string
+	if bl.trie != nil {
+		domains = bl.trie.Entries()
+	}
+	bl.mu.RUnlock()
+	slices.Sort(domains)
+
+	data, err := json.Marshal(domains)
 	if err != nil {
-		return nil, timing, fmt.Errorf("capturing screenshot: %w", err)
+		return nil, fmt.Errorf("encoding blocklist: %w", err)
 	}
+	return data, nil
+}
 
-	return screenshot, timing, nil
+func (bl *Blocklist) Len() int {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	if bl.trie == nil {
+		return 0
+	}
+	return bl.trie.size
 }
 
-func (s *Server) createRequestHandler() func(*rod.Hijack) {
-	return func(h *rod.Hijack) {
-		reqURL := h.Request.URL().String()
-		reqType := h.Request.Type()
+func (bl *Blocklist) IsBlocked(host string) bool {
+	bl.checks.Add(1)
 
-		if s.shouldBlock(reqURL, reqType) {
-			h.Response.Fail(proto.NetworkErrorReasonBlockedByClient)
-			return
+	matched, ok := bl.match(host)
+	if !ok {
+		return false
+	}
+
+	bl.blocked.Add(1)
+	bl.recordHit(matched)
+	return true
+}
+
+func (bl *Blocklist) IsBlockedIP(ip string) bool {
+	bl.checks.Add(1)
+
+	matched, ok := bl.matchIP(ip)
+	if !ok {
+		return false
+	}
+
+	bl.blocked.Add(1)
+	bl.recordHit(matched)
+	return true
+}
+
+func (bl *Blocklist) matchIP(ip string) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
+	}
+
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+
+	for _, cidr := range bl.cidrs {
+		if cidr.Contains(parsed) {
+			return cidr.String(), true
+		}
+	}
+	return "", false
+}
+
+func (bl *Blocklist) Reason(host string) string {
+	if _, ok := bl.matchIP(host); ok {
+		return "cidr"
+	}
+	matched, ok := bl.match(host)
+	if !ok {
+		return "allowed"
+	}
+	if slices.Contains(criticalDomains, matched) {
+		return "critical"
+	}
+	return "blocklist"
+}
+
+func (bl *Blocklist) match(host string) (string, bool) {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+
+	if bl.trie == nil {
+		return "", false
+	}
+	return bl.trie.Match(host)
+}
+
+func (bl *Blocklist) recordHit(domain string) {
+	bl.hitsMu.Lock()
+	defer bl.hitsMu.Unlock()
+
+	if bl.hits == nil {
+		bl.hits = make(map[string]int64)
+	}
+
+	if _, ok := bl.hits[domain]; !ok && len(bl.hits) >= maxTrackedBlocked {
+		var coldest string
+		var lowest int64 = -1
+		for d, n := range bl.hits {
+			if lowest < 0 || n < lowest {
+				coldest, lowest = d, n
+			}
+		}
+		delete(bl.hits, coldest)
+	}
+
+	bl.hits[domain]++
+}
+
+func (bl *Blocklist) topN(n int) []DomainCount {
+	bl.hitsMu.Lock()
+	top := make([]DomainCount, 0, len(bl.hits))
+	for d, c := range bl.hits {
+		top = append(top, DomainCount{Domain: d, Count: c})
+	}
+	bl.hitsMu.Unlock()
+
+	slices.SortFunc(top, func(a, b DomainCount) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func (bl *Blocklist) Stats() BlocklistStats {
+	checks := bl.checks.Load()
+	blocked := bl.blocked.Load()
+	return BlocklistStats{
+		Checks:     checks,
+		Blocked:    blocked,
+		Allowed:    checks - blocked,
+		TopBlocked: bl.topN(topBlockedDomains),
+	}
+}
+
+func (bl *Blocklist) ResetStats() {
+	bl.hitsMu.Lock()
+	defer bl.hitsMu.Unlock()
+
+	bl.checks.Store(0)
+	bl.blocked.Store(0)
+	bl.hits = nil
+}
+
+func NewServer(cfg Config, logger *slog.Logger, repo *ScreenshotRepository) (*Server, error) {
+	blocklist, err := NewBlocklist(logger)
+	if err != nil {
+		logger.Warn("failed to initialize blocklist", slog.String("error", err.Error()))
+		blocklist = &Blocklist{trie: newDomainTrie(), added: make(map[string]struct{}), logger: logger}
+	}
+
+	templates, err := parseTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("parsing templates: %w", err)
+	}
+
+	blockedPatterns := make([]*regexp.Regexp, 0, len(cfg.BlockedURLPatterns))
+	for _, pattern := range cfg.BlockedURLPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compiling blocked url pattern %q: %w", pattern, err)
+		}
+		blockedPatterns = append(blockedPatterns, re)
+	}
+
+	var store ScreenshotStore
+	switch cfg.StorageBackend {
+	case "", "sqlite":
+	case "redis":
+		store, err = NewRedisStore(cfg.RedisURL, cfg.RedisTTL, logger)
+		if err != nil {
+			return nil, fmt.Errorf("creating redis store: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+
+	for _, v := range cfg.APIVersions {
+		if !slices.Contains(supportedAPIVersions, v) {
+			return nil, fmt.Errorf("unsupported api version %q", v)
+		}
+	}
+
+	var watermark image.Image
+	if cfg.WatermarkPath != "" {
+		if watermark, err = loadWatermark(cfg.WatermarkPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.EnablePprof && !cfg.Debug {
+		logger.Warn("pprof endpoints are enabled outside debug mode, they are only protected by the admin password")
+	}
+
+	if cfg.SessionCookieTTL > 0 && !cfg.TLSEnabled() {
+		logger.Warn("session cookies are enabled without TLS, cookies will be sent without the Secure flag")
+	}
+
+	if cfg.BrowserProxy != "" {
+		if err := validateProxyURL(cfg.BrowserProxy); err != nil {
+			return nil, err
+		}
+	}
+
+	path, found := launcher.LookPath()
+	if !found {
+		return nil, ErrBrowserMissing
+	}
+
+	pool := NewBrowserPool(path, cfg.BrowserPoolSize, cfg.BrowserHealthCheckInterval, logger)
+	pool.proxy, pool.noProxy = cfg.BrowserProxy, cfg.NoProxyHosts
+
+	return &Server{
+		pool:            pool,
+		breaker:         NewCircuitBreaker(cfg.CBFailureThreshold, cfg.CBRecoveryWindow, logger),
+		perIP:           newIPLimiter(cfg.MaxConcurrentPerIP),
+		semaphore:       make(chan struct{}, cfg.MaxConcurrent),
+		config:          cfg,
+		logger:          logger,
+		blocklist:       blocklist,
+		templates:       templates,
+		repo:            repo,
+		store:           store,
+		startedAt:       time.Now(),
+		blockedPatterns: blockedPatterns,
+		watermark:       watermark,
+		robotsClient:    newRobotsClient(cfg.AllowPrivateIPs),
+	}, nil
+}
+
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(s.config.ShutdownTimeout, shutdownTimeout))
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown waits for in-flight captures until ctx is done, then closes the
+// browsers and storage. Only the first call does any work, so run() can pass
+// its shutdown context and still keep Close deferred for early returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.drainCaptures(ctx)
+		s.audits.Wait()
+		if s.store != nil {
+			s.store.Close()
+		}
+		if s.repo != nil {
+			s.repo.Close()
+		}
+		s.closeErr = s.pool.Close()
+	})
+	return s.closeErr
+}
+
+func (s *Server) drainCaptures(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("timed out waiting for in-flight captures, closing browsers anyway", slog.String("error", ctx.Err().Error()))
+	}
+}
+
+func (s *Server) ServeHTTP(mux *http.ServeMux) {
+	s.mux = mux
+	mux.Handle("GET /static/", http.FileServer(http.FS(assets.EmbeddedFiles)))
+	mux.HandleFunc("GET /robots.txt", s.handleRobots)
+	mux.HandleFunc("GET /ping", s.handlePing)
+	mux.HandleFunc("GET /version", s.handleVersion)
+	mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("GET /docs", s.handleDocs)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /healthz/deep", s.handleDeepHealth)
+	mux.HandleFunc("GET /favicon.ico", s.handleFavicon)
+	mux.HandleFunc("GET /site.webmanifest", s.handleWebManifest)
+	mux.HandleFunc("GET /blocked", s.handleBlocked)
+	mux.HandleFunc("GET /presets", s.handlePresets)
+	mux.HandleFunc("POST /admin/presets", s.basicAuth(s.handleSetPreset))
+	mux.HandleFunc("GET /domains.json", s.basicAuth(s.handleDomains))
+	mux.HandleFunc("GET /screenshots", s.basicAuth(s.handleScreenshots))
+	mux.HandleFunc("GET /screenshots/latest", s.basicAuth(s.handleLatestScreenshot))
+	mux.HandleFunc("POST /screenshots/batch", s.basicAuth(s.handleBatch))
+	mux.HandleFunc("POST /admin/blocklist/reload", s.basicAuth(s.handleBlocklistReload))
+	mux.HandleFunc("POST /admin/blocklist/cidrs", s.basicAuth(s.handleBlocklistAddCIDR))
+	mux.HandleFunc("GET /admin/blocklist/stats", s.basicAuth(s.handleBlocklistStats))
+	mux.HandleFunc("GET /admin/blocklist/export", s.basicAuth(s.handleBlocklistExport))
+	mux.HandleFunc("POST /admin/blocklist/stats/reset", s.basicAuth(s.handleBlocklistStatsReset))
+	mux.HandleFunc("GET /admin/audit", s.basicAuth(s.handleAudit))
+	mux.HandleFunc("GET /admin/stats", s.basicAuth(s.handleStats))
+	mux.HandleFunc("GET /admin/config", s.basicAuth(s.handleAdminConfig))
+	mux.HandleFunc("POST /admin/purge", s.basicAuth(s.handlePurge))
+	mux.HandleFunc("GET /admin/export", s.basicAuth(s.handleExport))
+	mux.HandleFunc("GET /{$}", s.corsMiddleware(s.handleScreenshot))
+	mux.HandleFunc("GET /preview", s.handlePreview)
+	mux.HandleFunc("OPTIONS /{$}", s.corsMiddleware(s.handleOptions))
+	for _, v := range s.config.APIVersions {
+		mux.HandleFunc("GET /"+v+"/screenshot", s.corsMiddleware(s.handleScreenshot))
+		mux.HandleFunc("OPTIONS /"+v+"/screenshot", s.corsMiddleware(s.handleOptions))
+		mux.HandleFunc("GET /"+v+"/preview", s.handlePreview)
+		mux.HandleFunc("GET /"+v+"/screenshots", s.basicAuth(s.handleScreenshots))
+		mux.HandleFunc("GET /"+v+"/screenshots/latest", s.basicAuth(s.handleLatestScreenshot))
+		mux.HandleFunc("POST /"+v+"/screenshots/batch", s.basicAuth(s.handleBatch))
+	}
+	if s.config.EnablePprof {
+		mux.HandleFunc("GET /debug/pprof/", s.basicAuth(pprof.Index))
+		mux.HandleFunc("GET /debug/pprof/cmdline", s.basicAuth(pprof.Cmdline))
+		mux.HandleFunc("GET /debug/pprof/profile", s.basicAuth(pprof.Profile))
+		mux.HandleFunc("GET /debug/pprof/symbol", s.basicAuth(pprof.Symbol))
+		mux.HandleFunc("POST /debug/pprof/symbol", s.basicAuth(pprof.Symbol))
+		mux.HandleFunc("GET /debug/pprof/trace", s.basicAuth(pprof.Trace))
+	}
+	mux.HandleFunc("/", s.handleNotFound)
+}
+
+func withSecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Robots-Tag", "noindex, nofollow")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = generateUUID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// validRequestID reports whether a client-supplied request id is safe to echo
+// back and write into every log line for the request.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) accessLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if s.config.SilenceHealthLogs && (strings.HasPrefix(r.URL.Path, "/healthz") || r.URL.Path == "/ping") {
+			return
+		}
+
+		s.loggerFrom(r.Context()).Info("request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		)
+	})
+}
+
+func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.config.AllowedOrigins) == 0 {
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if allowed := s.allowedOrigin(origin); allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Add("Vary", "Access-Control-Request-Headers")
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+func (s *Server) allowedOrigin(origin string) string {
+	for _, o := range s.config.AllowedOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (s *Server) loggerFrom(ctx context.Context) *slog.Logger {
+	if id := requestIDFromContext(ctx); id != "" {
+		return s.logger.With(slog.String("request_id", id))
+	}
+	return s.logger
+}
+
+func (s *Server) cache() ScreenshotStore {
+	if s.store != nil {
+		return s.store
+	}
+	if s.repo != nil {
+		return s.repo
+	}
+	return nil
+}
+
+func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(s.allowedMethods(r), ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if methods := s.allowedMethods(r); len(methods) > 0 {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		s.handleError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	s.templates["404"].Execute(w, PageData{Title: "404 - Not Found"})
+}
+
+func (s *Server) allowedMethods(r *http.Request) []string {
+	if s.mux == nil {
+		return nil
+	}
+
+	var methods []string
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions} {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := s.mux.Handler(probe); pattern != "" && pattern != "/" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.templates["index"].Execute(w, PageData{Title: "Screenshot"})
+}
+
+func (s *Server) handleError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	s.templates["error"].Execute(w, PageData{
+		Title:   fmt.Sprintf("%d - Error", code),
+		Code:    code,
+		Message: sanitizeMessage(message),
+	})
+}
+
+func sanitizeMessage(message string) string {
+	for {
+		stripped := stripTags(message)
+		if stripped == message {
+			return stripped
+		}
+		message = stripped
+	}
+}
+
+func stripTags(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if message[i] == '<' && i+1 < len(message) && isTagStart(message[i+1]) {
+			end := strings.IndexByte(message[i:], '>')
+			if end == -1 {
+				break
+			}
+			i += end
+			continue
+		}
+		b.WriteByte(message[i])
+	}
+	return b.String()
+}
+
+func isTagStart(c byte) bool {
+	return c == '/' || c == '!' || c == '?' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func (s *Server) handleRobots(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("User-agent: *\nDisallow: /\n"))
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(PingResponse{TS: time.Now().Unix(), Version: Version})
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, _ *http.Request) {
+	apiVersion := supportedAPIVersions[len(supportedAPIVersions)-1]
+	if n := len(s.config.APIVersions); n > 0 {
+		apiVersion = s.config.APIVersions[n-1]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionResponse{APIVersion: apiVersion, ServerVersion: Version})
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	data, err := assets.EmbeddedFiles.ReadFile("openapi.json")
+	if err != nil {
+		s.loggerFrom(r.Context()).Error("failed to read openapi spec", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
+
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	specURL := scheme + "://" + r.Host + "/openapi.json"
+	http.Redirect(w, r, swaggerUIURL+"?url="+url.QueryEscape(specURL), http.StatusFound)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	if s.repo != nil {
+		if err := s.repo.Ping(); err != nil {
+			http.Error(w, "database connection failed", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if s.store != nil {
+		if err := s.store.Ping(); err != nil {
+			http.Error(w, "cache connection failed", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	health := HealthStatus{
+		Status:   "ok",
+		Captures: s.totalCaptures.Load(),
+		Errors:   s.totalErrors.Load(),
+	}
+	hits, misses := s.cacheHits.Load(), s.cacheMisses.Load()
+	if total := hits + misses; total > 0 {
+		health.CacheHitRate = float64(hits) / float64(total)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(health)
+}
+
+func (s *Server) handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	health := DeepHealth{
+		DB:                 "ok",
+		Browser:            "ok",
+		SemaphoreAvailable: cap(s.semaphore) - len(s.semaphore),
+		UptimeSeconds:      int64(time.Since(s.startedAt).Seconds()),
+		CacheHits:          s.cacheHits.Load(),
+		CacheMisses:        s.cacheMisses.Load(),
+		GoroutineCount:     runtime.NumGoroutine(),
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	health.MemoryAllocMB = float64(mem.Alloc) / (1 << 20)
+	health.MemorySysMB = float64(mem.Sys) / (1 << 20)
+	health.NumGC = mem.NumGC
+
+	if limit := s.config.MaxGoroutineWarnThreshold; limit > 0 && health.GoroutineCount > limit {
+		health.Warnings = append(health.Warnings, "goroutine_count_high")
+	}
+
+	if s.repo == nil {
+		health.DB = "not configured"
+	} else if err := s.repo.Ping(); err != nil {
+		s.loggerFrom(r.Context()).Error("deep health db check failed", slog.String("error", err.Error()))
+		health.DB = "error"
+	} else if err := s.repo.IntegrityCheck(); err != nil {
+		s.loggerFrom(r.Context()).Error("deep health db integrity check failed", slog.String("error", err.Error()))
+		health.DB = "error"
+	}
+
+	if s.store != nil {
+		health.Cache = "ok"
+		if err := s.store.Ping(); err != nil {
+			s.loggerFrom(r.Context()).Error("deep health cache check failed", slog.String("error", err.Error()))
+			health.Cache = "error"
+		}
+	}
+
+	if err := s.checkBrowser(r.Context()); errors.Is(err, errBrowserBusy) {
+		health.Browser = "busy"
+	} else if err != nil {
+		s.loggerFrom(r.Context()).Error("deep health browser check failed", slog.String("error", err.Error()))
+		health.Browser = "error"
+	}
+
+	status := http.StatusOK
+	if health.DB == "error" || health.Cache == "error" || health.Browser == "error" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+type acquiredPage struct {
+	page *rod.Page
+	err  error
+}
+
+// checkBrowser opens a tab like a capture would, so it takes a capture slot
+// and reports errBrowserBusy instead of queueing when none is free. The
+// health check timeout covers launching the browser as well.
+func (s *Server) checkBrowser(ctx context.Context) error {
+	if s.pool == nil {
+		return ErrBrowserMissing
+	}
+	if !s.tryAcquire() {
+		return errBrowserBusy
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.HealthCheckTimeout)
+	defer cancel()
+
+	acquired := make(chan acquiredPage, 1)
+	go func() {
+		page, err := s.pool.AcquirePage()
+		acquired <- acquiredPage{page, err}
+	}()
+
+	var page *rod.Page
+	select {
+	case res := <-acquired:
+		if res.err != nil {
+			s.release()
+			return res.err
+		}
+		page = res.page
+	case <-ctx.Done():
+		// Keep the slot until the launch finishes so slow launches still
+		// count against MaxConcurrent.
+		go func() {
+			if res := <-acquired; res.err == nil {
+				s.pool.ReleasePage(res.page)
+			}
+			s.release()
+		}()
+		return fmt.Errorf("opening a browser page: %w", ctx.Err())
+	}
+	defer s.release()
+	defer s.pool.ReleasePage(page)
+
+	if err := page.Context(ctx).Navigate("about:blank"); err != nil {
+		return fmt.Errorf("navigating to about:blank: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) handleFavicon(w http.ResponseWriter, _ *http.Request) {
+	data, err := assets.EmbeddedFiles.ReadFile("static/favicon.ico")
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
+	w.Write(data)
+}
+
+func (s *Server) handleWebManifest(w http.ResponseWriter, _ *http.Request) {
+	data, err := assets.EmbeddedFiles.ReadFile("static/site.webmanifest")
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
+	w.Write(data)
+}
+
+func (s *Server) handleBlocked(w http.ResponseWriter, r *http.Request) {
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		http.Error(w, "missing domain parameter", http.StatusBadRequest)
+		return
+	}
+
+	reason := s.blocklist.Reason(strings.ToLower(domain))
+	blocked := reason != "allowed"
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(BlockedResponse{Domain: domain, Blocked: blocked, Reason: reason})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if blocked {
+		w.Write([]byte("blocked"))
+	} else {
+		w.Write([]byte("allowed"))
+	}
+}
+
+func (s *Server) handlePresets(w http.ResponseWriter, _ *http.Request) {
+	presetsMu.RLock()
+	data, err := json.Marshal(presets)
+	presetsMu.RUnlock()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
+	w.Write(data)
+}
+
+func (s *Server) handleSetPreset(w http.ResponseWriter, r *http.Request) {
+	var req PresetRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "missing preset name", http.StatusBadRequest)
+		return
+	}
+
+	if req.Width <= 0 || req.Width > s.config.MaxWidth || req.Height <= 0 || req.Height > s.config.MaxHeight {
+		http.Error(w, fmt.Sprintf("dimensions must be within %dx%d", s.config.MaxWidth, s.config.MaxHeight), http.StatusBadRequest)
+		return
+	}
+
+	dim := Dimension{Width: req.Width, Height: req.Height}
+	presetsMu.Lock()
+	presets[req.Name] = dim
+	presetsMu.Unlock()
+
+	s.loggerFrom(r.Context()).Info("preset updated",
+		slog.String("name", req.Name),
+		slog.Int("width", dim.Width),
+		slog.Int("height", dim.Height),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]Dimension{req.Name: dim})
+}
+
+func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request) {
+	if prefix := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("prefix"))); prefix != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
+		json.NewEncoder(w).Encode(s.blocklist.WithPrefix(prefix))
+		return
+	}
+
+	data, err := assets.EmbeddedFiles.ReadFile("filters/domains.json")
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticCacheTTL))
+	w.Write(data)
+}
+
+func (s *Server) handleBlocklistReload(w http.ResponseWriter, r *http.Request) {
+	before := s.blocklist.Len()
+	if err := s.blocklist.Reload(); err != nil {
+		s.loggerFrom(r.Context()).Error("failed to reload blocklist", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"old_domains": before,
+		"new_domains": s.blocklist.Len(),
+	})
+}
+
+func (s *Server) handleBlocklistAddCIDR(w http.ResponseWriter, r *http.Request) {
+	var req CIDRRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.blocklist.AddCIDR(req.CIDR); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.loggerFrom(r.Context()).Info("blocklist cidr added", slog.String("cidr", req.CIDR))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(req)
+}
+
+func (s *Server) handleBlocklistExport(w http.ResponseWriter, r *http.Request) {
+	data, err := s.blocklist.ExportJSON()
+	if err != nil {
+		s.loggerFrom(r.Context()).Error("failed to export blocklist", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+func (s *Server) handleBlocklistStats(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.blocklist.Stats())
+}
+
+func (s *Server) handleBlocklistStatsReset(w http.ResponseWriter, r *http.Request) {
+	s.blocklist.ResetStats()
+	s.loggerFrom(r.Context()).Info("blocklist stats reset")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if s.repo == nil {
+		http.Error(w, "database not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since parameter, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	limit := parseIntParam(r, "limit", defaultAuditLimit, maxAuditLimit)
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	records, err := s.repo.ListCaptures(since, limit+1, offset)
+	if err != nil {
+		s.loggerFrom(r.Context()).Error("failed to list captures", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	page := AuditPage{Captures: records}
+	if len(records) > limit {
+		page.Captures = records[:limit]
+		page.NextOffset = offset + limit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if s.repo == nil {
+		http.Error(w, "database not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	stats, err := s.repo.GetStats()
+	if err != nil {
+		s.loggerFrom(r.Context()).Error("failed to get stats", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := StatsResponse{
+		Repository:  stats,
+		CacheHits:   s.cacheHits.Load(),
+		CacheMisses: s.cacheMisses.Load(),
+	}
+	if s.pool != nil {
+		resp.Browsers = s.pool.Stats()
+	}
+	if total := resp.CacheHits + resp.CacheMisses; total > 0 {
+		resp.HitRate = float64(resp.CacheHits) / float64(total)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if s.repo == nil {
+		http.Error(w, "database not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	filename := fmt.Sprintf("screenshot-backup-%s.sqlite", time.Now().UTC().Format(time.DateOnly))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if err := s.repo.Export(recorder); err != nil {
+		s.loggerFrom(r.Context()).Error("failed to export database", slog.String("error", err.Error()))
+		if recorder.bytes == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	s.loggerFrom(r.Context()).Info("database exported", slog.String("filename", filename))
+}
+
+func (s *Server) handleAdminConfig(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.config.View())
+}
+
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	cache := s.cache()
+	if cache == nil {
+		http.Error(w, "database not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req PurgeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Prefix) == "" {
+		http.Error(w, "missing prefix", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.loggerFrom(r.Context())
+
+	count, err := cache.CountByURLPrefix(req.Prefix)
+	if err != nil {
+		logger.Error("failed to count screenshots for purge", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if count > int64(s.config.MaxPurgeRows) {
+		http.Error(w, fmt.Sprintf("prefix matches %d screenshots, more than the limit of %d", count, s.config.MaxPurgeRows), http.StatusUnprocessableEntity)
+		return
+	}
+
+	deleted, err := cache.PurgeByURLPrefix(req.Prefix)
+	if err != nil {
+		logger.Error("failed to purge screenshots", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("purged screenshots", slog.String("prefix", req.Prefix), slog.Int64("deleted", deleted))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": deleted})
+}
+
+func (s *Server) recordCapture(ctx context.Context, rec CaptureRecord) {
+	if s.repo == nil {
+		return
+	}
+
+	logger := s.loggerFrom(ctx)
+	s.audits.Add(1)
+	go func() {
+		defer s.audits.Done()
+		if err := s.repo.RecordCapture(rec); err != nil {
+			logger.Warn("failed to record capture", slog.String("url", rec.URL), slog.String("error", err.Error()))
+		}
+	}()
+}
+
+func (s *Server) handleLatestScreenshot(w http.ResponseWriter, r *http.Request) {
+	cache := s.cache()
+	if cache == nil {
+		http.Error(w, "database not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	targetURL := r.URL.Query().Get("url")
+	if targetURL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+
+	data, contentType, createdAt, err := cache.Latest(normalizeURL(targetURL))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "no screenshot for url", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.loggerFrom(r.Context()).Error("failed to get latest screenshot", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	w.Header().Set("X-Captured-At", createdAt.UTC().Format(http.TimeFormat))
+	w.Write(data)
+}
+
+func (s *Server) handleScreenshots(w http.ResponseWriter, r *http.Request) {
+	cache := s.cache()
+	if cache == nil {
+		http.Error(w, "database not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	page := parseIntParam(r, "page", 1, math.MaxInt32)
+	perPage := parseIntParam(r, "per_page", defaultPerPage, maxPerPage)
+
+	order, err := parseListOrder(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	screenshots, total, err := cache.List((page-1)*perPage, perPage, order)
+	if err != nil {
+		s.logger.Error("failed to list screenshots", slog.String("error", err.Error()))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", screenshotsCacheTTL))
+		json.NewEncoder(w).Encode(ScreenshotsPage{
+			Data:    screenshots,
+			Total:   total,
+			Page:    page,
+			PerPage: perPage,
+		})
+		return
+	}
+
+	data := ScreenshotsPageData{
+		Title:       "Screenshots",
+		Screenshots: screenshots,
+		Total:       total,
+		Sort:        order.Sort,
+		Order:       r.URL.Query().Get("order"),
+	}
+	if page > 1 {
+		data.PrevPage = page - 1
+	}
+	if int64(page*perPage) < total {
+		data.NextPage = page + 1
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", screenshotsCacheTTL))
+	s.templates["screenshots"].Execute(w, data)
+}
+
+func (s *Server) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	logger := s.loggerFrom(r.Context())
+
+	userAgent := r.Header.Get("User-Agent")
+	targetURL := r.URL.Query().Get("url")
+	if targetURL != "" {
+		targetURL = normalizeURL(targetURL)
+	}
+
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = recorder
+	audit := CaptureRecord{
+		URL:       targetURL,
+		Format:    "webp",
+		RemoteIP:  s.realIP(r),
+		UserAgent: userAgent,
+	}
+	defer func() {
+		// Without a url this is a visit to the index page, not a capture.
+		if audit.URL == "" {
+			return
+		}
+		audit.StatusCode = recorder.status
+		audit.DurationMs = time.Since(start).Milliseconds()
+		audit.CacheHit = recorder.Header().Get("X-Cache") == "HIT"
+		s.recordCapture(r.Context(), audit)
+	}()
+
+	if s.isBot(userAgent) {
+		logger.Warn("blocked bot request", slog.String("ua", userAgent), slog.String("ip", audit.RemoteIP))
+		s.handleError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if targetURL == "" {
+		s.handleIndex(w, r)
+		return
+	}
+
+	if err := s.validateTargetURL(r.Context(), targetURL); err != nil {
+		logger.Warn("rejected target url", slog.String("url", targetURL), slog.String("error", err.Error()))
+		s.handleError(w, targetURLErrorStatus(err), err.Error())
+		return
+	}
+
+	opts, err := s.parseCaptureOptions(r)
+	if err != nil {
+		s.handleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.ImageFormat == "" {
+		opts.ImageFormat = negotiateFormat(r.Header.Get("Accept"))
+	}
+	w.Header().Add("Vary", "Accept")
+	audit.Width, audit.Height, audit.Format = opts.Width, opts.Height, opts.Format()
+	variant := opts.Variant()
+
+	encode := r.URL.Query().Get("encode")
+	if encode != "" && encode != "base64" {
+		s.handleError(w, http.StatusBadRequest, "encode must be base64")
+		return
+	}
+	if encode != "" && opts.Format() == "html" {
+		s.handleError(w, http.StatusBadRequest, "encode is not supported with format=html")
+		return
+	}
+
+	etagFor := func(createdAt time.Time) string {
+		etag := generateETag(targetURL, opts.Width, opts.Height, opts.Format(), variant, createdAt)
+		if encode == "base64" {
+			etag += "-base64"
+		}
+		return etag
+	}
+	disposition := contentDisposition(targetURL, opts, r.URL.Query().Get("download") == "true")
+	if r.URL.Query().Get("no_cache") == "true" {
+		if !s.authenticate(w, r) {
+			return
+		}
+		opts.NoCache = true
+	}
+	s.setDebugHeaders(w, cacheKeyFor(targetURL, opts.Width, opts.Height, opts.Format(), variant))
+
+	shot, timing, hit, err := s.screenshot(r.Context(), targetURL, opts, audit.RemoteIP)
+	if hit {
+		etag := etagFor(shot.CreatedAt)
+		if notModified(r, etag, shot.CreatedAt) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		logger.Info("screenshot served from cache",
+			slog.String("url", targetURL),
+			slog.Int("width", opts.Width),
+			slog.Int("height", opts.Height),
+		)
+		if encode == "base64" {
+			w.Header().Set("X-Cache", "HIT")
+			s.writeDataURI(w, r, shot, etag)
+			return
+		}
+		s.writeCachedResponse(w, r, shot, etag, disposition)
+		return
+	}
+
+	if err != nil {
+		s.totalErrors.Add(1)
+		s.handleCaptureError(w, r, targetURL, err, timing)
+		return
+	}
+	s.totalCaptures.Add(1)
+	screenshot := shot.Data
+
+	logger.Info("screenshot captured",
+		slog.String("url", targetURL),
+		slog.Int64("queue_ms", timing.Queue.Milliseconds()),
+		slog.Int64("setup_ms", timing.Setup.Milliseconds()),
+		slog.Int64("nav_ms", timing.Navigation.Milliseconds()),
+		slog.Int64("load_ms", timing.Load.Milliseconds()),
+		slog.Int64("screenshot_ms", timing.Screenshot.Milliseconds()),
+		slog.Int64("total_ms", timing.Total.Milliseconds()),
+		slog.Int("size_kb", len(screenshot)/1024),
+	)
+
+	shot.Timing = timing
+	if opts.Format() == "html" {
+		s.writeHTMLSnapshot(w, shot)
+		return
+	}
+	etag := etagFor(shot.CreatedAt)
+	if encode == "base64" {
+		s.writeDataURI(w, r, shot, etag)
+		return
+	}
+	s.writeResponse(w, r, shot, etag, disposition)
+}
+
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if s.isBot(r.Header.Get("User-Agent")) {
+		s.handleError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	targetURL := r.URL.Query().Get("url")
+	if targetURL == "" {
+		s.handleError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	targetURL = normalizeURL(targetURL)
+
+	if err := s.validateTargetURL(r.Context(), targetURL); err != nil {
+		s.handleError(w, targetURLErrorStatus(err), err.Error())
+		return
+	}
+
+	opts, err := s.parseCaptureOptions(r)
+	if err != nil {
+		s.handleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Format() == "html" {
+		s.handleError(w, http.StatusBadRequest, "format=html is not supported by the preview")
+		return
+	}
+
+	shot, timing, hit, err := s.screenshot(r.Context(), targetURL, opts, s.realIP(r))
+	if err != nil {
+		s.handleCaptureError(w, r, targetURL, err, timing)
+		return
+	}
+
+	width, height := opts.Width, opts.Height
+	if w, h, err := parseWebPDimensions(shot.Data); err == nil {
+		width, height = w, h
+	}
+
+	data := PreviewPageData{
+		Title:  "Preview - " + targetURL,
+		URL:    targetURL,
+		APIURL: "/?" + r.URL.RawQuery,
+		Image:  template.URL("data:" + shot.ContentType + ";base64," + base64.StdEncoding.EncodeToString(shot.Data)),
+		Width:  width,
+		Height: height,
+		Size:   len(shot.Data),
+		Cached: hit,
+		Timings: []PreviewTiming{
+			{"Queue", timing.Queue.Milliseconds()},
+			{"Setup", timing.Setup.Milliseconds()},
+			{"Navigation", timing.Navigation.Milliseconds()},
+			{"Load", timing.Load.Milliseconds()},
+			{"Screenshot", timing.Screenshot.Milliseconds()},
+			{"Total", timing.Total.Milliseconds()},
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	s.templates["preview"].Execute(w, data)
+}
+
+func (s *Server) screenshot(ctx context.Context, targetURL string, opts CaptureOptions, remoteIP string) (CachedScreenshot, Timing, bool, error) {
+	var timing Timing
+	captureFn := func(ctx context.Context) (CachedScreenshot, error) {
+		if err := s.breaker.Allow(); err != nil {
+			return CachedScreenshot{}, err
+		}
+		if err := s.perIP.acquire(ctx, remoteIP); err != nil {
+			s.breaker.Cancel()
+			return CachedScreenshot{}, err
+		}
+		defer s.perIP.release(remoteIP)
+		queueStart := time.Now()
+		if err := s.acquire(ctx); err != nil {
+			s.breaker.Cancel()
+			timing.Queue = time.Since(queueStart)
+			return CachedScreenshot{}, err
+		}
+		defer s.release()
+		queue := time.Since(queueStart)
+
+		screenshot, t, err := s.captureWithRetry(ctx, targetURL, opts)
+		s.breaker.Record(err)
+		t.Queue = queue
+		timing = t
+		if err != nil {
+			return CachedScreenshot{}, err
+		}
+		return CachedScreenshot{
+			Data:        screenshot,
+			ContentType: opts.ContentType(),
+			Timing:      t,
+			CreatedAt:   time.Now().UTC().Truncate(time.Second),
+			TTL:         opts.TTL,
+			Private:     opts.Login != nil,
+		}, nil
+	}
+
+	cache := s.cache()
+	if cache == nil || opts.FullPage || opts.Format() == "html" || opts.Login != nil {
+		shot, err := captureFn(ctx)
+		return shot, timing, false, err
+	}
+
+	if opts.NoCache {
+		s.cacheMisses.Add(1)
+		shot, err := captureFn(ctx)
+		if err != nil {
+			return shot, timing, false, err
+		}
+		if err := cache.Save(targetURL, shot, opts.Width, opts.Height, opts.Format(), opts.Variant()); err != nil {
+			s.loggerFrom(ctx).Warn("failed to cache screenshot", slog.String("url", targetURL), slog.String("error", err.Error()))
+		}
+		return shot, timing, false, nil
+	}
+
+	// Concurrent requests for the same key share one capture, so it must not
+	// be cancelled when the request that happened to start it goes away.
+	shot, hit, err := cache.GetOrCreate(targetURL, opts.Width, opts.Height, opts.Format(), opts.Variant(), s.cacheMaxAge(), func() (CachedScreenshot, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cmp.Or(opts.Timeout, s.config.PageTimeout))
+		defer cancel()
+		return captureFn(ctx)
+	})
+	if hit {
+		s.cacheHits.Add(1)
+		return shot, shot.Timing, true, nil
+	}
+	s.cacheMisses.Add(1)
+	if errors.Is(err, ErrCacheWrite) {
+		s.loggerFrom(ctx).Warn("failed to cache screenshot", slog.String("url", targetURL), slog.String("error", err.Error()))
+		err = nil
+	}
+	if timing == (Timing{}) {
+		// Requests that joined another's capture report that capture's timing.
+		timing = shot.Timing
+	}
+	return shot, timing, false, err
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	logger := s.loggerFrom(r.Context())
+
+	var items []BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&items); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(items) == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+
+	if len(items) > s.config.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("batch exceeds maximum of %d items", s.config.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	start := time.Now()
+	results := make([]BatchResult, len(items))
+	remoteIP := s.realIP(r)
+
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.captureBatchItem(r.Context(), item, remoteIP)
+		}()
+	}
+	wg.Wait()
+
+	var captureTotal time.Duration
+	failed := 0
+	for _, res := range results {
+		captureTotal += res.timing.Total
+		if res.Error != "" {
+			failed++
+		}
+	}
+	elapsed := time.Since(start)
+
+	logger.Info("batch captured",
+		slog.Int("items", len(items)),
+		slog.Int("failed", failed),
+		slog.Int64("capture_ms", captureTotal.Milliseconds()),
+		slog.Int64("total_ms", elapsed.Milliseconds()),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Batch-Items", strconv.Itoa(len(items)))
+	w.Header().Set("X-Batch-Failed", strconv.Itoa(failed))
+	w.Header().Set("X-Capture-Ms", strconv.FormatInt(captureTotal.Milliseconds(), 10))
+	w.Header().Set("X-Total-Ms", strconv.FormatInt(elapsed.Milliseconds(), 10))
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logger.Error("failed to write batch response", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) warmUp(urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+
+	cache := s.cache()
+	if cache == nil {
+		s.logger.Warn("skipping warm-up, no cache configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), warmUpTimeout)
+	defer cancel()
+
+	dim, _ := lookupPreset("thumb")
+	start := time.Now()
+	var warmed int
+
+	for _, raw := range urls {
+		if ctx.Err() != nil {
+			s.logger.Warn("warm-up timed out", slog.Int("remaining", len(urls)-warmed))
+			break
+		}
+
+		targetURL := normalizeURL(raw)
+		itemStart := time.Now()
+
+		if err := s.validateTargetURL(ctx, targetURL); err != nil {
+			s.logger.Warn("warm-up skipped url", slog.String("url", targetURL), slog.String("error", err.Error()))
+			continue
+		}
+
+		opts := CaptureOptions{Width: dim.Width, Height: dim.Height}
+		_, hit, err := cache.GetOrCreate(targetURL, opts.Width, opts.Height, defaultFormat, "", s.cacheMaxAge(), func() (CachedScreenshot, error) {
+			if err := s.breaker.Allow(); err != nil {
+				return CachedScreenshot{}, err
+			}
+			if err := s.perIP.acquire(ctx, warmUpIP); err != nil {
+				s.breaker.Cancel()
+				return CachedScreenshot{}, err
+			}
+			defer s.perIP.release(warmUpIP)
+			if err := s.acquire(ctx); err != nil {
+				s.breaker.Cancel()
+				return CachedScreenshot{}, err
+			}
+			defer s.release()
+
+			screenshot, timing, err := s.captureWithRetry(ctx, targetURL, opts)
+			s.breaker.Record(err)
+			if err != nil {
+				return CachedScreenshot{}, err
+			}
+			return CachedScreenshot{Data: screenshot, ContentType: opts.ContentType(), Timing: timing}, nil
+		})
+		if err != nil && !errors.Is(err, ErrCacheWrite) {
+			s.logger.Warn("warm-up capture failed", slog.String("url", targetURL), slog.String("error", err.Error()))
+			continue
+		}
+
+		warmed++
+		s.logger.Info("warm-up capture",
+			slog.String("url", targetURL),
+			slog.Bool("cached", hit),
+			slog.Int64("ms", time.Since(itemStart).Milliseconds()),
+		)
+	}
+
+	s.logger.Info("warm-up finished",
+		slog.Int("warmed", warmed),
+		slog.Int("total", len(urls)),
+		slog.Int64("ms", time.Since(start).Milliseconds()),
+	)
+}
+
+func loadWarmUpURLs(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading warm-up file: %w", err)
+	}
+
+	var urls []string
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return nil, fmt.Errorf("parsing warm-up file: %w", err)
+	}
+	return urls, nil
+}
+
+func (s *Server) captureBatchItem(ctx context.Context, item BatchRequest, remoteIP string) BatchResult {
+	if item.URL == "" {
+		return BatchResult{Error: "missing url"}
+	}
+
+	targetURL := normalizeURL(item.URL)
+	result := BatchResult{URL: targetURL}
+
+	if err := s.validateTargetURL(ctx, targetURL); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	dim, _ := lookupPreset("thumb")
+	opts := CaptureOptions{
+		Width:  clampDimension(item.Width, dim.Width, s.config.MaxWidth),
+		Height: clampDimension(item.Height, dim.Height, s.config.MaxHeight),
+	}
+
+	// Items share the cache and its single flight with GET requests, so a
+	// batch only reaches the browser for screenshots nobody has cached.
+	shot, timing, _, err := s.screenshot(ctx, targetURL, opts, remoteIP)
+	result.timing = timing
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		result.Error = "request cancelled"
+		return result
+	}
+	if err != nil {
+		s.loggerFrom(ctx).Error("batch item failed",
+			slog.String("url", targetURL),
+			slog.String("error", err.Error()),
+		)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Data = shot.Data
+	result.ContentType = shot.ContentType
+	return result
+}
+
+func (s *Server) validateTargetURL(ctx context.Context, rawURL string) error {
+	if s.config.MaxURLLength > 0 && len(rawURL) > s.config.MaxURLLength {
+		return fmt.Errorf("url exceeds maximum length of %d", s.config.MaxURLLength)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("url is malformed")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url scheme must be http or https")
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("url host is empty")
+	}
+
+	if len(s.config.AllowedHosts) > 0 && !hostAllowed(strings.ToLower(host), s.config.AllowedHosts) {
+		return ErrHostNotAllowed
+	}
+
+	if s.config.AllowPrivateIPs {
+		return nil
+	}
+
+	private, err := resolvesToPrivateIP(ctx, host)
+	if err != nil {
+		return fmt.Errorf("url host %q could not be resolved", host)
+	}
+	if private {
+		return errors.New("url resolves to a private network address")
+	}
+
+	return nil
+}
+
+func resolvesToPrivateIP(ctx context.Context, host string) (bool, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return isPrivateIP(ip), nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if isPrivateIP(a.IP) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// privateHosts remembers which hosts resolved to a private address during a
+// single capture, so a page pulling many subresources from one host costs one
+// lookup instead of one per request.
+type privateHosts struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+func (p *privateHosts) blocked(ctx context.Context, host string) bool {
+	p.mu.Lock()
+	blocked, ok := p.hosts[host]
+	p.mu.Unlock()
+	if ok {
+		return blocked
+	}
+
+	private, err := resolvesToPrivateIP(ctx, host)
+	blocked = err != nil || private
+
+	p.mu.Lock()
+	if p.hosts == nil {
+		p.hosts = make(map[string]bool)
+	}
+	p.hosts[host] = blocked
+	p.mu.Unlock()
+	return blocked
+}
+
+// Redirects, subresources and iframes are checked as well, since the browser
+// resolves hosts on its own and would otherwise reach internal addresses.
+func (s *Server) blocksPrivateRequest(ctx context.Context, reqURL string, hosts *privateHosts) bool {
+	if s.config.AllowPrivateIPs {
+		return false
+	}
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return true
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return false
+	}
+	return hosts.blocked(ctx, u.Hostname())
+}
+
+func (s *Server) acquire(ctx context.Context) error {
+	select {
+	case s.semaphore <- struct{}{}:
+		s.inflight.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) tryAcquire() bool {
+	select {
+	case s.semaphore <- struct{}{}:
+		s.inflight.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) release() {
+	s.inflight.Done()
+	<-s.semaphore
+}
+
+type ipLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	slots     map[string]*ipSlot
+	lastSweep time.Time
+}
+
+type ipSlot struct {
+	sem      chan struct{}
+	lastUsed time.Time
+}
+
+func newIPLimiter(limit int) *ipLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &ipLimiter{limit: limit, slots: make(map[string]*ipSlot), lastSweep: time.Now()}
+}
+
+func (l *ipLimiter) acquire(ctx context.Context, ip string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > ipSweepInterval {
+		l.sweep(now)
+	}
+	slot, ok := l.slots[ip]
+	if !ok {
+		slot = &ipSlot{sem: make(chan struct{}, l.limit)}
+		l.slots[ip] = slot
+	}
+	slot.lastUsed = now
+	l.mu.Unlock()
+
+	select {
+	case slot.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *ipLimiter) release(ip string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	slot := l.slots[ip]
+	slot.lastUsed = time.Now()
+	l.mu.Unlock()
+
+	<-slot.sem
+}
+
+func (l *ipLimiter) sweep(now time.Time) {
+	for ip, slot := range l.slots {
+		if len(slot.sem) == 0 && now.Sub(slot.lastUsed) > ipSweepInterval {
+			delete(l.slots, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (s *Server) parseDimensions(r *http.Request) (int, int) {
+	dim, _ := lookupPreset("thumb")
+	if preset := r.URL.Query().Get("preset"); preset != "" {
+		if p, ok := lookupPreset(preset); ok {
+			dim = p
+		}
+	}
+
+	width, height := dim.Width, dim.Height
+
+	if r.URL.Query().Get("width") != "" {
+		width = parseIntParam(r, "width", width, s.config.MaxWidth)
+	}
+	if r.URL.Query().Get("height") != "" {
+		height = parseIntParam(r, "height", height, s.config.MaxHeight)
+	}
+
+	return width, height
+}
+
+func (s *Server) parseCaptureOptions(r *http.Request) (CaptureOptions, error) {
+	width, height := s.parseDimensions(r)
+	opts := CaptureOptions{
+		Width:    width,
+		Height:   height,
+		FullPage: r.URL.Query().Get("full") == "true",
+		CSS:      r.URL.Query().Get("css"),
+	}
+
+	if len(opts.CSS) > s.config.MaxCSSBytes {
+		return opts, fmt.Errorf("css exceeds maximum of %d bytes", s.config.MaxCSSBytes)
+	}
+
+	if preload := r.URL.Query().Get("preload_css"); preload != "" {
+		u, err := s.validatePreloadCSS(preload)
+		if err != nil {
+			return opts, err
+		}
+		opts.PreloadCSS = u
+	}
+
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return opts, fmt.Errorf("invalid locale %q", locale)
+		}
+		opts.Locale = tag.String()
+	}
+
+	if timezone := r.URL.Query().Get("timezone"); timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+			return opts, fmt.Errorf("invalid timezone %q", timezone)
+		}
+		opts.Timezone = timezone
+	}
+
+	if referer := r.URL.Query().Get("referer"); referer != "" && s.config.AllowExtraHeaders {
+		u, err := url.Parse(referer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return opts, fmt.Errorf("invalid referer %q", referer)
+		}
+		opts.Referer = u.String()
+	}
+
+	if s.config.AllowTargetAuth {
+		opts.AuthUser, opts.AuthPass = r.URL.Query().Get("auth_user"), r.URL.Query().Get("auth_pass")
+		if opts.AuthUser == "" && opts.AuthPass != "" {
+			return opts, errors.New("auth_pass requires auth_user")
+		}
+	}
+
+	if s.config.AllowBrowserInteraction {
+		login, err := parseLoginForm(r.URL.Query())
+		if err != nil {
+			return opts, err
+		}
+		opts.Login = login
+	}
+
+	if v := r.URL.Query().Get("mock_date"); v != "" && s.config.AllowDateMocking {
+		mockDate, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid mock_date %q: must be RFC3339, e.g. 2024-12-25T00:00:00Z", v)
+		}
+		opts.MockDate = mockDate
+	}
+
+	opts.NoJS = r.URL.Query().Get("no_js") == "true" && s.config.AllowNoJS
+	opts.ScrollDown = r.URL.Query().Get("scroll_to_bottom") == "true"
+	opts.StripGA = r.URL.Query().Get("inject_ga") == "false"
+	opts.BlockThirdParty = r.URL.Query().Get("block_third_party") == "true"
+
+	switch media := r.URL.Query().Get("media"); media {
+	case "", "screen":
+	case "print":
+		opts.Media = media
+	default:
+		return opts, fmt.Errorf("invalid media %q: must be screen or print", media)
+	}
+
+	if r.URL.Query().Get("network_idle") == "true" {
+		opts.NetworkIdle = !s.config.BlockFonts && !s.config.BlockMedia
+	}
+
+	if selector := r.URL.Query().Get("wait_for"); selector != "" {
+		if len(selector) > maxSelectorLen {
+			return opts, fmt.Errorf("wait_for exceeds maximum of %d characters", maxSelectorLen)
+		}
+		opts.WaitFor = selector
+	}
+
+	if d := r.URL.Query().Get("delay"); d != "" {
+		ms, err := strconv.Atoi(d)
+		if err != nil || ms < 0 {
+			return opts, errors.New("delay must be a non-negative number of milliseconds")
+		}
+		opts.Delay = min(time.Duration(ms)*time.Millisecond, maxDelay)
+	}
+
+	if c := r.URL.Query().Get("clip"); c != "" {
+		clip, err := s.parseClip(c)
+		if err != nil {
+			return opts, err
+		}
+		opts.Clip = clip
+		opts.Width = max(opts.Width, clip.X+clip.Width)
+		opts.Height = max(opts.Height, clip.Y+clip.Height)
+	}
+
+	if r.URL.Query().Get("above_fold") == "true" {
+		if opts.Clip != nil {
+			return opts, errors.New("above_fold cannot be combined with clip")
+		}
+		opts.AboveFold = true
+		opts.FullPage = false
+	}
+
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		secs, err := strconv.Atoi(t)
+		if err != nil {
+			return opts, errors.New("timeout must be a whole number of seconds")
+		}
+		opts.Timeout = clampPageTimeout(secs, s.config.MaxPageTimeout)
+	}
+
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 1 || secs > s.config.MaxTTLSecs {
+			return opts, fmt.Errorf("ttl must be between 1 and %d seconds", s.config.MaxTTLSecs)
+		}
+		opts.TTL = time.Duration(secs) * time.Second
+	}
+
+	if r.URL.Query().Get("transparent") == "true" {
+		if f := r.URL.Query().Get("format"); f == "jpeg" || f == "jpg" || f == "html" {
+			return opts, fmt.Errorf("transparent is not supported with %s", f)
+		}
+		opts.Transparent = true
+	}
+
+	switch f := r.URL.Query().Get("format"); f {
+	case "html", "webp", "png", "jpeg":
+		opts.ImageFormat = f
+	case "jpg":
+		opts.ImageFormat = "jpeg"
+	}
+
+	if position := r.URL.Query().Get("watermark"); position != "" {
+		switch {
+		case s.watermark == nil:
+			return opts, errors.New("watermark is not configured")
+		case !slices.Contains(watermarkPositions, position):
+			return opts, fmt.Errorf("invalid watermark %q: must be one of %s", position, strings.Join(watermarkPositions, ", "))
+		case opts.Clip != nil || opts.ImageFormat == "html":
+			return opts, errors.New("watermark cannot be combined with clip or format=html")
+		}
+		opts.Watermark = position
+	}
+
+	if q := r.URL.Query().Get("quality"); q != "" && s.config.AllowQualityOverride {
+		quality, err := strconv.Atoi(q)
+		if err != nil || quality < 1 || quality > 100 {
+			return opts, errors.New("quality must be between 1 and 100")
+		}
+		if quality != s.config.ScreenshotQual {
+			opts.Quality = quality
+		}
+	}
+
+	return opts, nil
+}
+
+func validateProxyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Port() == "" {
+		return fmt.Errorf("invalid browser proxy %q: must be scheme://host:port", raw)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid browser proxy %q: scheme must be http, https or socks5", raw)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("invalid browser proxy %q: must be scheme://host:port", raw)
+	}
+	return nil
+}
+
+func (s *Server) validatePreloadCSS(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return "", errors.New("preload_css must be an https url")
+	}
+
+	if !slices.ContainsFunc(s.config.AllowedCSSHosts, func(h string) bool { return strings.EqualFold(h, u.Hostname()) }) {
+		return "", fmt.Errorf("preload_css host %q is not allowed", u.Hostname())
+	}
+
+	return u.String(), nil
+}
+
+func (s *Server) fetchPreloadCSS(ctx context.Context, cssURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cssFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cssURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("building preload css request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching preload css: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching preload css: unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(s.config.MaxCSSBytes)+1))
+	if err != nil {
+		return "", fmt.Errorf("reading preload css: %w", err)
+	}
+	if len(data) > s.config.MaxCSSBytes {
+		return "", fmt.Errorf("preload css exceeds maximum of %d bytes", s.config.MaxCSSBytes)
+	}
+
+	return string(data), nil
+}
+
+func parseLoginForm(q url.Values) (*LoginForm, error) {
+	login := &LoginForm{
+		FormSelector:   q.Get("login_selector"),
+		UserSelector:   q.Get("login_user_selector"),
+		PassSelector:   q.Get("login_pass_selector"),
+		SubmitSelector: q.Get("login_submit_selector"),
+		User:           q.Get("login_user"),
+		Pass:           q.Get("login_pass"),
+	}
+	if *login == (LoginForm{}) {
+		return nil, nil
+	}
+	if login.UserSelector == "" || login.PassSelector == "" || login.SubmitSelector == "" || login.User == "" {
+		return nil, errors.New("login requires login_user_selector, login_pass_selector, login_submit_selector and login_user")
+	}
+	for _, selector := range []string{login.FormSelector, login.UserSelector, login.PassSelector, login.SubmitSelector} {
+		if len(selector) > maxSelectorLen {
+			return nil, fmt.Errorf("login selectors must not exceed %d characters", maxSelectorLen)
+		}
+	}
+	return login, nil
+}
+
+func submitLoginForm(page *rod.Page, login *LoginForm) error {
+	if login.FormSelector != "" {
+		has, _, err := page.Has(login.FormSelector)
+		if err != nil {
+			return fmt.Errorf("checking for login form: %w", err)
+		}
+		if !has {
+			return nil
+		}
+	}
+
+	fields := []struct{ selector, value string }{
+		{login.UserSelector, login.User},
+		{login.PassSelector, login.Pass},
+	}
+	for _, f := range fields {
+		el, err := page.Element(f.selector)
+		if err != nil {
+			return fmt.Errorf("login field timeout: %w", err)
+		}
+		if err := el.Input(f.value); err != nil {
+			return fmt.Errorf("filling login field: %w", err)
+		}
+	}
+
+	submit, err := page.Element(login.SubmitSelector)
+	if err != nil {
+		return fmt.Errorf("login submit timeout: %w", err)
+	}
+	if err := submit.Click(proto.InputMouseButtonLeft, 1); err != nil {
+		return fmt.Errorf("submitting login form: %w", err)
+	}
+	if err := page.WaitLoad(); err != nil {
+		return fmt.Errorf("load timeout after login: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) parseClip(value string) (*Clip, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, errors.New("clip must be x,y,width,height")
+	}
+
+	var vals [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return nil, errors.New("clip values must be non-negative integers")
+		}
+		vals[i] = n
+	}
+
+	clip := &Clip{X: vals[0], Y: vals[1], Width: vals[2], Height: vals[3]}
+	if clip.Width == 0 || clip.Height == 0 {
+		return nil, errors.New("clip width and height must be greater than zero")
+	}
+	if clip.X+clip.Width > s.config.MaxWidth || clip.Y+clip.Height > s.config.MaxHeight {
+		return nil, fmt.Errorf("clip must fit within %dx%d", s.config.MaxWidth, s.config.MaxHeight)
+	}
+
+	return clip, nil
+}
+
+// Variant joins the options that change the output into part of the cache
+// key. Free-form values are query-escaped so they cannot forge another pair.
+func (o CaptureOptions) Variant() string {
+	var parts []string
+	if o.CSS != "" {
+		sum := sha256.Sum256([]byte(o.CSS))
+		parts = append(parts, "css="+hex.EncodeToString(sum[:16]))
+	}
+	if o.Quality != 0 {
+		parts = append(parts, "q="+strconv.Itoa(o.Quality))
+	}
+	if o.Locale != "" {
+		parts = append(parts, "locale="+url.QueryEscape(o.Locale))
+	}
+	if o.Timezone != "" {
+		parts = append(parts, "tz="+url.QueryEscape(o.Timezone))
+	}
+	if o.Referer != "" {
+		parts = append(parts, "referer="+url.QueryEscape(o.Referer))
+	}
+	if o.WaitFor != "" {
+		parts = append(parts, "wait_for="+url.QueryEscape(o.WaitFor))
+	}
+	if o.NetworkIdle {
+		parts = append(parts, "network_idle")
+	}
+	if o.ScrollDown {
+		parts = append(parts, "scroll_to_bottom")
+	}
+	if o.Media != "" {
+		parts = append(parts, "media="+url.QueryEscape(o.Media))
+	}
+	if o.Delay != 0 {
+		parts = append(parts, "delay="+strconv.FormatInt(o.Delay.Milliseconds(), 10))
+	}
+	if o.Clip != nil {
+		parts = append(parts, fmt.Sprintf("clip=%d,%d,%d,%d", o.Clip.X, o.Clip.Y, o.Clip.Width, o.Clip.Height))
+	}
+	if o.Transparent {
+		parts = append(parts, "transparent")
+	}
+	if o.Dark {
+		parts = append(parts, "dark")
+	}
+	if o.BlockThirdParty {
+		parts = append(parts, "block_third_party")
+	}
+	if o.PreloadCSS != "" {
+		parts = append(parts, "preload_css="+url.QueryEscape(o.PreloadCSS))
+	}
+	if o.AboveFold {
+		parts = append(parts, "above_fold")
+	}
+	if o.Watermark != "" {
+		parts = append(parts, "watermark="+url.QueryEscape(o.Watermark))
+	}
+	if o.NoJS {
+		parts = append(parts, "no_js")
+	}
+	if !o.MockDate.IsZero() {
+		parts = append(parts, "mock_date="+o.MockDate.UTC().Format(time.RFC3339))
+	}
+	return strings.Join(parts, "&")
+}
+
+func (o CaptureOptions) Format() string {
+	if o.ImageFormat == "html" {
+		return "html"
+	}
+	if o.Transparent {
+		return "png"
+	}
+	return cmp.Or(o.ImageFormat, defaultFormat)
+}
+
+func negotiateFormat(accept string) string {
+	best, bestQ := defaultFormat, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := strings.CutPrefix(mediaType, "image/")
+		if !ok || (format != "webp" && format != "png" && format != "jpeg") {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+func (o CaptureOptions) ContentType() string {
+	if o.Format() == "html" {
+		return "text/html; charset=utf-8"
+	}
+	return "image/" + o.Format()
+}
+
+func (s *Server) captureWithRetry(ctx context.Context, url string, opts CaptureOptions) ([]byte, Timing, error) {
+	delay := s.config.CaptureRetryDelay
+	for attempt := 1; ; attempt++ {
+		screenshot, timing, err := s.capture(ctx, url, opts)
+		if err == nil || attempt > s.config.CaptureRetries || !isRetryableCaptureError(err) {
+			return screenshot, timing, err
+		}
+
+		s.loggerFrom(ctx).Warn("retrying capture",
+			slog.String("url", url),
+			slog.Int("attempt", attempt),
+			slog.Duration("delay", delay),
+			slog.String("error", err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return screenshot, timing, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func isRetryableCaptureError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "ERR_CONNECTION") || strings.Contains(msg, "ERR_ABORTED")
+}
+
+func (s *Server) capture(ctx context.Context, url string, opts CaptureOptions) ([]byte, Timing, error) {
+	ctx, span := startCaptureSpan(ctx, url)
+	data, timing, err := s.capturePage(ctx, url, opts)
+	span.end(timing, err)
+	return data, timing, err
+}
+
+func (s *Server) capturePage(ctx context.Context, url string, opts CaptureOptions) (_ []byte, timing Timing, err error) {
+	totalStart := time.Now()
+
+	if s.config.RespectRobotsTxt {
+		if err := s.checkRobots(ctx, url); err != nil {
+			return nil, timing, err
+		}
+	}
+
+	var preloadCSS string
+	if opts.PreloadCSS != "" {
+		css, err := s.fetchPreloadCSS(ctx, opts.PreloadCSS)
+		if err != nil {
+			return nil, timing, err
+		}
+		preloadCSS = css
+	}
+
+	setupStart := time.Now()
+	page, err := s.pool.AcquirePage()
+	if err != nil {
+		return nil, timing, err
+	}
+	defer s.pool.ReleasePage(page)
+
+	var crashed atomic.Bool
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	go page.Context(watchCtx).EachEvent(func(*proto.InspectorTargetCrashed) {
+		crashed.Store(true)
+	})()
+	defer func() {
+		if err != nil && !errors.Is(err, ErrBrowserFailure) && (crashed.Load() || errors.Is(err, cdp.ErrSessionNotFound)) {
+			err = fmt.Errorf("%w: target crashed: %w", ErrBrowserFailure, err)
+		}
+	}()
+	if err := (proto.InspectorEnable{}).Call(page); err != nil {
+		return nil, timing, fmt.Errorf("%w: enabling inspector: %w", ErrBrowserFailure, err)
+	}
+
+	if s.config.BrowserUserAgent != "" {
+		if err := page.SetUserAgent(&proto.NetworkSetUserAgentOverride{UserAgent: s.config.BrowserUserAgent}); err != nil {
+			return nil, timing, fmt.Errorf("setting user agent: %w", err)
+		}
+	}
+
+	if err := page.SetViewport(&proto.EmulationSetDeviceMetricsOverride{
+		Width:             opts.Width,
+		Height:            opts.Height,
+		DeviceScaleFactor: 1.0,
+	}); err != nil {
+		return nil, timing, fmt.Errorf("setting viewport: %w", err)
+	}
+
+	var extraHeaders []string
+	if opts.Locale != "" {
+		if err := (proto.EmulationSetLocaleOverride{Locale: opts.Locale}).Call(page); err != nil {
+			return nil, timing, fmt.Errorf("setting locale: %w", err)
+		}
+		extraHeaders = append(extraHeaders, "Accept-Language", opts.Locale)
+	}
+	if opts.Referer != "" {
+		extraHeaders = append(extraHeaders, "Referer", opts.Referer)
+	}
+	if opts.AuthUser != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(opts.AuthUser + ":" + opts.AuthPass))
+		extraHeaders = append(extraHeaders, "Authorization", "Basic "+credentials)
+	}
+	if len(extraHeaders) > 0 {
+		if _, err := page.SetExtraHeaders(extraHeaders); err != nil {
+			return nil, timing, fmt.Errorf("setting extra headers: %w", err)
+		}
+	}
+
+	if opts.Timezone != "" {
+		if err := (proto.EmulationSetTimezoneOverride{TimezoneID: opts.Timezone}).Call(page); err != nil {
+			return nil, timing, fmt.Errorf("setting timezone: %w", err)
+		}
+	}
+
+	media := proto.EmulationSetEmulatedMedia{Media: cmp.Or(opts.Media, "screen")}
+	if opts.Dark {
+		media.Features = []*proto.EmulationMediaFeature{{Name: "prefers-color-scheme", Value: "dark"}}
+	}
+	if err := media.Call(page); err != nil {
+		return nil, timing, fmt.Errorf("setting media type: %w", err)
+	}
+
+	if opts.NoJS {
+		if err := (proto.EmulationSetScriptExecutionDisabled{Value: true}).Call(page); err != nil {
+			return nil, timing, fmt.Errorf("disabling javascript: %w", err)
+		}
+	}
+
+	if opts.Transparent {
+		if err := (proto.EmulationSetDefaultBackgroundColorOverride{Color: &proto.DOMRGBA{}}).Call(page); err != nil {
+			return nil, timing, fmt.Errorf("setting transparent background: %w", err)
+		}
+	}
+
+	if preloadCSS != "" {
+		cssJSON, err := json.Marshal(preloadCSS)
+		if err != nil {
+			return nil, timing, fmt.Errorf("encoding preload css: %w", err)
+		}
+		if _, err := page.EvalOnNewDocument(fmt.Sprintf(preloadCSSScript, cssJSON)); err != nil {
+			return nil, timing, fmt.Errorf("injecting preload css: %w", err)
+		}
+	}
+
+	if !opts.MockDate.IsZero() {
+		if _, err := page.EvalOnNewDocument(fmt.Sprintf(mockDateScript, opts.MockDate.UnixMilli())); err != nil {
+			return nil, timing, fmt.Errorf("mocking date: %w", err)
+		}
+	}
+
+	router := page.HijackRequests()
+	var firstParty string
+	if opts.BlockThirdParty {
+		firstParty = firstPartyDomain(url)
+	}
+	router.MustAdd("*", s.createRequestHandler(ctx, firstParty))
+	go router.Run()
+	defer router.MustStop()
+	timing.Setup = time.Since(setupStart)
+
+	navStart := time.Now()
+	timeout := s.config.PageTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+
+	if err := page.Timeout(timeout).Navigate(url); err != nil {
+		timing.Navigation = time.Since(navStart)
+		return nil, timing, fmt.Errorf("navigation timeout: %w", err)
+	}
+	timing.Navigation = time.Since(navStart)
+
+	loadStart := time.Now()
+	if err := page.Timeout(timeout).WaitLoad(); err != nil {
+		timing.Load = time.Since(loadStart)
+		return nil, timing, fmt.Errorf("load timeout: %w", err)
+	}
+
+	if opts.Login != nil {
+		if err := submitLoginForm(page.Timeout(timeout), opts.Login); err != nil {
+			timing.Load = time.Since(loadStart)
+			return nil, timing, err
+		}
+	}
+
+	if opts.ScrollDown {
+		if err := s.scrollToBottom(ctx, page.Timeout(timeout), !opts.FullPage); err != nil {
+			timing.Load = time.Since(loadStart)
+			return nil, timing, err
+		}
+	}
+
+	if opts.NetworkIdle {
+		if remaining := timeout - time.Since(navStart); remaining > 0 {
+			page.Timeout(remaining).WaitRequestIdle(networkIdleWindow, nil, nil, []proto.NetworkResourceType{
+				proto.NetworkResourceTypeWebSocket,
+				proto.NetworkResourceTypeEventSource,
+			})()
+		}
+	}
+
+	if opts.WaitFor != "" {
+		if _, err := page.Timeout(timeout).Element(opts.WaitFor); err != nil {
+			timing.Load = time.Since(loadStart)
+			s.loggerFrom(ctx).Warn("wait_for selector not found", slog.String("url", url), slog.String("selector", opts.WaitFor))
+			return nil, timing, fmt.Errorf("wait_for timeout: %w", err)
+		}
+	}
+
+	if opts.Delay > 0 {
+		select {
+		case <-time.After(opts.Delay):
+		case <-ctx.Done():
+			timing.Load = time.Since(loadStart)
+			return nil, timing, ctx.Err()
+		}
+	}
+
+	if s.config.Debug && (opts.Locale != "" || opts.Timezone != "") {
+		if res, err := page.Eval(`() => Intl.DateTimeFormat().resolvedOptions()`); err == nil {
+			s.loggerFrom(ctx).Debug("resolved intl options",
+				slog.String("locale", res.Value.Get("locale").Str()),
+				slog.String("timezone", res.Value.Get("timeZone").Str()),
+			)
+		}
+	}
+
+	if opts.CSS != "" {
+		if _, err := page.Eval(`(css) => document.head.appendChild(Object.assign(document.createElement('style'), {textContent: css}))`, opts.CSS); err != nil {
+			timing.Load = time.Since(loadStart)
+			return nil, timing, fmt.Errorf("injecting css: %w", err)
+		}
+	}
+	if opts.StripGA {
+		res, err := page.Eval(`() => { const scripts = document.querySelectorAll('script[src*="google"], script[src*="gtag"]'); scripts.forEach(el => el.remove()); return scripts.length }`)
+		if err != nil {
+			timing.Load = time.Since(loadStart)
+			return nil, timing, fmt.Errorf("removing analytics scripts: %w", err)
+		}
+		s.loggerFrom(ctx).Debug("removed analytics scripts", slog.String("url", url), slog.Int("count", res.Value.Int()))
+	}
+	if opts.Transparent {
+		if _, err := page.Eval(`() => { document.documentElement.style.background = 'transparent'; if (document.body) document.body.style.background = 'transparent' }`); err != nil {
+			timing.Load = time.Since(loadStart)
+			return nil, timing, fmt.Errorf("clearing background: %w", err)
+		}
+	}
+	timing.Load = time.Since(loadStart)
+
+	screenshotStart := time.Now()
+	if opts.Format() == "html" {
+		html, err := page.HTML()
+		timing.Screenshot = time.Since(screenshotStart)
+		timing.Total = time.Since(totalStart)
+		if err != nil {
+			return nil, timing, fmt.Errorf("reading page html: %w", err)
+		}
+		return []byte(html), timing, nil
+	}
+
+	quality := s.config.ScreenshotQual
+	if opts.Quality != 0 {
+		quality = opts.Quality
+	}
+	req := &proto.PageCaptureScreenshot{
+		Format:           proto.PageCaptureScreenshotFormatWebp,
+		Quality:          &quality,
+		OptimizeForSpeed: true,
+	}
+	switch opts.Format() {
+	case "png":
+		req.Format = proto.PageCaptureScreenshotFormatPng
+		req.Quality = nil
+	case "jpeg":
+		req.Format = proto.PageCaptureScreenshotFormatJpeg
+	}
+	if opts.Clip != nil {
+		req.Clip = &proto.PageViewport{
+			X:      float64(opts.Clip.X),
+			Y:      float64(opts.Clip.Y),
+			Width:  float64(opts.Clip.Width),
+			Height: float64(opts.Clip.Height),
+			Scale:  1,
+		}
+	}
+	if opts.AboveFold {
+		req.Clip = &proto.PageViewport{Width: float64(opts.Width), Height: float64(opts.Height), Scale: 1}
+	}
+	if opts.Watermark != "" {
+		req.Format = proto.PageCaptureScreenshotFormatPng
+		req.Quality = nil
+	}
+	screenshot, err := page.Screenshot(opts.FullPage && opts.Clip == nil, req)
+	if err == nil && opts.Watermark != "" {
+		screenshot, err = applyWatermark(page.Browser(), screenshot, s.watermark, opts.Watermark, opts.Format(), quality)
+	}
+	timing.Screenshot = time.Since(screenshotStart)
+	timing.Total = time.Since(totalStart)
+
+	if err != nil {
+		return nil, timing, fmt.Errorf("capturing screenshot: %w", err)
+	}
+
+	return screenshot, timing, nil
+}
+
+func (s *Server) scrollToBottom(ctx context.Context, page *rod.Page, backToTop bool) error {
+	for range s.config.MaxScrollSteps {
+		res, err := page.Eval(`() => {
+			window.scrollBy(0, window.innerHeight);
+			const height = (document.body || document.documentElement).scrollHeight;
+			return window.scrollY + window.innerHeight >= height;
+		}`)
+		if err != nil {
+			return fmt.Errorf("scrolling page: %w", err)
+		}
+		if res.Value.Bool() {
+			break
+		}
+
+		select {
+		case <-time.After(scrollStepInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if backToTop {
+		if _, err := page.Eval(`() => window.scrollTo(0, 0)`); err != nil {
+			return fmt.Errorf("scrolling to top: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) createRequestHandler(ctx context.Context, firstParty string) func(*rod.Hijack) {
+	logger := s.loggerFrom(ctx)
+	hosts := &privateHosts{}
+	return func(h *rod.Hijack) {
+		reqURL := h.Request.URL().String()
+		reqType := h.Request.Type()
+
+		if firstParty != "" && isThirdParty(reqURL, reqType, firstParty) {
+			if s.config.Debug {
+				logger.Debug("blocked third party", slog.String("type", string(reqType)), slog.String("url", reqURL))
+			}
+			h.Response.Fail(proto.NetworkErrorReasonBlockedByClient)
+			return
+		}
+
+		if s.shouldBlock(reqURL, reqType) {
+			h.Response.Fail(proto.NetworkErrorReasonBlockedByClient)
+			return
+		}
+
+		// Resolving is the expensive check, so it only runs for requests
+		// that would otherwise be let through.
+		if s.blocksPrivateRequest(ctx, reqURL, hosts) {
+			logger.Warn("blocked request to private address", slog.String("type", string(reqType)), slog.String("url", reqURL))
+			h.Response.Fail(proto.NetworkErrorReasonAddressUnreachable)
+			return
 		}
 
 		if s.config.Debug {
-			s.logger.Debug("fetching", slog.String("type", string(reqType)), slog.String("url", reqURL))
+			logger.Debug("fetching", slog.String("type", string(reqType)), slog.String("url", reqURL))
 		}
 		h.ContinueRequest(&proto.FetchContinueRequest{})
 	}
-}
+}
+
+func (s *Server) shouldBlock(reqURL string, reqType proto.NetworkResourceType) bool {
+	if s.config.BlockFonts && reqType == proto.NetworkResourceTypeFont {
+		if s.config.Debug {
+			s.logger.Debug("blocked font", slog.String("url", reqURL))
+		}
+		return true
+	}
+
+	if s.config.BlockMedia {
+		if reqType == proto.NetworkResourceTypeMedia || reqType == proto.NetworkResourceTypeWebSocket {
+			if s.config.Debug {
+				s.logger.Debug("blocked media", slog.String("type", string(reqType)), slog.String("url", reqURL))
+			}
+			return true
+		}
+		if idx := strings.LastIndexByte(reqURL, '.'); idx != -1 {
+			if _, blocked := blockedExtensions[reqURL[idx:]]; blocked {
+				if s.config.Debug {
+					s.logger.Debug("blocked media", slog.String("url", reqURL))
+				}
+				return true
+			}
+		}
+	}
+
+	switch reqType {
+	case proto.NetworkResourceTypeXHR,
+		proto.NetworkResourceTypeFetch,
+		proto.NetworkResourceTypePing,
+		proto.NetworkResourceTypePrefetch,
+		proto.NetworkResourceTypeSignedExchange,
+		proto.NetworkResourceTypeEventSource,
+		proto.NetworkResourceTypeManifest:
+		if s.config.Debug {
+			s.logger.Debug("blocked unnecessary", slog.String("type", string(reqType)), slog.String("url", reqURL))
+		}
+		return true
+	}
+
+	for _, path := range blockedPaths {
+		if strings.Contains(reqURL, path) {
+			if s.config.Debug {
+				s.logger.Debug("blocked favicon/manifest", slog.String("url", reqURL))
+			}
+			return true
+		}
+	}
+
+	if reqType != proto.NetworkResourceTypeStylesheet && reqType != proto.NetworkResourceTypeDocument {
+		host := extractHost(reqURL)
+		if s.blocklist.IsBlocked(host) {
+			if s.config.Debug {
+				s.logger.Debug("blocked by blocklist", slog.String("url", reqURL))
+			}
+			return true
+		}
+		if net.ParseIP(host) != nil && s.blocklist.IsBlockedIP(host) {
+			if s.config.Debug {
+				s.logger.Debug("blocked by ip range", slog.String("url", reqURL))
+			}
+			return true
+		}
+	}
+
+	for _, re := range s.blockedPatterns {
+		if re.MatchString(reqURL) {
+			if s.config.Debug {
+				s.logger.Debug("blocked by pattern", slog.String("pattern", re.String()), slog.String("url", reqURL))
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) handleCaptureError(w http.ResponseWriter, r *http.Request, url string, err error, timing Timing) {
+	s.loggerFrom(r.Context()).Error("screenshot failed",
+		slog.String("url", url),
+		slog.String("error", err.Error()),
+		slog.Int64("queue_ms", timing.Queue.Milliseconds()),
+		slog.Int64("elapsed_ms", timing.Total.Milliseconds()),
+	)
+
+	if errors.Is(err, context.Canceled) {
+		s.handleError(w, http.StatusServiceUnavailable, "Request cancelled")
+		return
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.config.CBRecoveryWindow.Seconds())))
+		s.handleError(w, http.StatusServiceUnavailable, "Screenshot service temporarily unavailable")
+		return
+	}
+
+	if errors.Is(err, ErrRobotsDisallowed) {
+		s.handleError(w, http.StatusForbidden, "Site disallows screenshots via robots.txt")
+		return
+	}
+
+	if strings.Contains(err.Error(), "timeout") {
+		s.handleError(w, http.StatusGatewayTimeout, "Timeout loading page")
+		return
+	}
+
+	s.handleError(w, http.StatusInternalServerError, "Failed to capture screenshot")
+}
+
+func (s *Server) setDebugHeaders(w http.ResponseWriter, cacheKey string) {
+	if !s.config.Debug {
+		return
+	}
+
+	w.Header().Set("X-Cache-Key", cacheKey)
+	w.Header().Set("X-Blocking-Rules", strings.Join(s.blockingRules(), ","))
+}
+
+func (s *Server) blockingRules() []string {
+	var rules []string
+	if s.config.BlockFonts {
+		rules = append(rules, "fonts")
+	}
+	if s.config.BlockMedia {
+		rules = append(rules, "media")
+	}
+	if s.blocklist != nil && s.blocklist.Len() > 0 {
+		rules = append(rules, "blocklist")
+	}
+	if len(s.blockedPatterns) > 0 {
+		rules = append(rules, "patterns")
+	}
+	return rules
+}
+
+func (s *Server) cacheMaxAge() time.Duration {
+	return time.Duration(s.config.CacheTTLSecs) * time.Second
+}
+
+func (s *Server) cacheControl(shot CachedScreenshot) string {
+	if shot.Private {
+		return "private, no-store"
+	}
+	secs := s.config.CacheTTLSecs
+	if shot.TTL > 0 {
+		secs = int(shot.TTL.Seconds())
+	}
+	return fmt.Sprintf("public, max-age=%d", secs)
+}
+
+func (s *Server) writeResponse(w http.ResponseWriter, r *http.Request, shot CachedScreenshot, etag, disposition string) {
+	w.Header().Set("Content-Type", shot.ContentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Cache-Control", s.cacheControl(shot))
+	w.Header().Set("ETag", etag)
+	setTimingHeaders(w, shot.Timing)
+	setImageDimensionHeaders(w, shot.Data)
+
+	if err := writeBody(w, r, shot.Data, etag); err != nil {
+		s.logger.Error("failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) writeHTMLSnapshot(w http.ResponseWriter, shot CachedScreenshot) {
+	w.Header().Set("Content-Type", shot.ContentType)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(shot.Data)))
+	setTimingHeaders(w, shot.Timing)
+
+	if _, err := w.Write(shot.Data); err != nil {
+		s.logger.Error("failed to write html snapshot", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) writeCachedResponse(w http.ResponseWriter, r *http.Request, shot CachedScreenshot, etag, disposition string) {
+	w.Header().Set("Content-Type", shot.ContentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Cache-Control", s.cacheControl(shot))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Cache", "HIT")
+	setTimingHeaders(w, shot.Timing)
+	if shot.ContentType == "image/webp" {
+		setImageDimensionHeaders(w, shot.Data)
+	}
+	if !shot.CreatedAt.IsZero() {
+		age := max(int64(time.Since(shot.CreatedAt).Seconds()), 0)
+		w.Header().Set("X-Cache-Age", strconv.FormatInt(age, 10))
+		w.Header().Set("Last-Modified", shot.CreatedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if err := writeBody(w, r, shot.Data, etag); err != nil {
+		s.logger.Error("failed to write cached response", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) writeDataURI(w http.ResponseWriter, r *http.Request, shot CachedScreenshot, etag string) {
+	dataURI := "data:" + shot.ContentType + ";base64," + base64.StdEncoding.EncodeToString(shot.Data)
+
+	w.Header().Set("Cache-Control", s.cacheControl(shot))
+	w.Header().Set("ETag", etag)
+	setTimingHeaders(w, shot.Timing)
+
+	var err error
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(map[string]string{"data_uri": dataURI})
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(dataURI)))
+		_, err = io.WriteString(w, dataURI)
+	}
+	if err != nil {
+		s.logger.Error("failed to write data uri response", slog.String("error", err.Error()))
+	}
+}
+
+func writeBody(w http.ResponseWriter, r *http.Request, data []byte, etag string) error {
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		rangeHeader = ""
+	}
+
+	if rangeHeader != "" {
+		start, end, err := parseRange(rangeHeader, len(data))
+		switch {
+		case errors.Is(err, ErrRangeNotSatisfiable):
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		case err == nil:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start))
+			w.WriteHeader(http.StatusPartialContent)
+			_, err = w.Write(data[start:end])
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err := w.Write(data)
+	return err
+}
+
+func parseRange(header string, size int) (start, end int, err error) {
+	unit, spec, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return 0, 0, ErrInvalidRange
+	}
+	spec = strings.TrimSpace(spec)
+	if strings.Contains(spec, ",") {
+		return 0, 0, ErrInvalidRange
+	}
+
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, ErrInvalidRange
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
 
-func (s *Server) shouldBlock(reqURL string, reqType proto.NetworkResourceType) bool {
-	if s.config.BlockFonts && reqType == proto.NetworkResourceTypeFont {
-		if s.config.Debug {
-			s.logger.Debug("blocked font", slog.String("url", reqURL))
+	if first == "" {
+		n, err := parseRangeInt(last)
+		if err != nil {
+			return 0, 0, err
 		}
-		return true
+		if n == 0 || size == 0 {
+			return 0, 0, ErrRangeNotSatisfiable
+		}
+		return max(size-n, 0), size, nil
 	}
 
-	if s.config.BlockMedia {
-		if reqType == proto.NetworkResourceTypeMedia || reqType == proto.NetworkResourceTypeWebSocket {
-			if s.config.Debug {
-				s.logger.Debug("blocked media", slog.String("type", string(reqType)), slog.String("url", reqURL))
-			}
-			return true
+	start, err = parseRangeInt(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	end = size
+	if last != "" {
+		n, err := parseRangeInt(last)
+		if err != nil {
+			return 0, 0, err
 		}
-		if idx := strings.LastIndexByte(reqURL, '.'); idx != -1 {
-			if _, blocked := blockedExtensions[reqURL[idx:]]; blocked {
-				if s.config.Debug {
-					s.logger.Debug("blocked media", slog.String("url", reqURL))
-				}
-				return true
-			}
+		if n < start {
+			return 0, 0, ErrInvalidRange
 		}
+		end = min(n+1, size)
 	}
 
-	switch reqType {
-	case proto.NetworkResourceTypeXHR,
-		proto.NetworkResourceTypeFetch,
-		proto.NetworkResourceTypePing,
-		proto.NetworkResourceTypePrefetch,
-		proto.NetworkResourceTypeSignedExchange,
-		proto.NetworkResourceTypeEventSource,
-		proto.NetworkResourceTypeManifest:
-		if s.config.Debug {
-			s.logger.Debug("blocked unnecessary", slog.String("type", string(reqType)), slog.String("url", reqURL))
-		}
-		return true
+	if start >= size {
+		return 0, 0, ErrRangeNotSatisfiable
 	}
+	return start, end, nil
+}
 
-	for _, path := range blockedPaths {
-		if strings.Contains(reqURL, path) {
-			if s.config.Debug {
-				s.logger.Debug("blocked favicon/manifest", slog.String("url", reqURL))
-			}
-			return true
-		}
+func parseRangeInt(s string) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrInvalidRange
 	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ErrInvalidRange
+	}
+	return n, nil
+}
 
-	if reqType != proto.NetworkResourceTypeStylesheet && reqType != proto.NetworkResourceTypeDocument {
-		host := extractHost(reqURL)
-		if s.blocklist.IsBlocked(host) {
-			if s.config.Debug {
-				s.logger.Debug("blocked by blocklist", slog.String("url", reqURL))
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (s *Server) realIP(r *http.Request) string {
+	if s.config.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip.String()
+			}
+		}
+		if cf := r.Header.Get("CF-Connecting-IP"); cf != "" {
+			if ip := net.ParseIP(strings.TrimSpace(cf)); ip != nil {
+				return ip.String()
 			}
-			return true
 		}
 	}
 
-	return false
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
-func (s *Server) handleCaptureError(w http.ResponseWriter, url string, err error, timing Timing) {
-	s.logger.Error("screenshot failed",
-		slog.String("url", url),
-		slog.String("error", err.Error()),
-		slog.Int64("elapsed_ms", timing.Total.Milliseconds()),
-	)
+func httpsRedirectHandler(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
 
-	if strings.Contains(err.Error(), "timeout") {
-		s.handleError(w, http.StatusGatewayTimeout, "Timeout loading page")
-		return
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return inm == etag
 	}
+	return notModifiedSince(r, modified)
+}
 
-	s.handleError(w, http.StatusInternalServerError, "Failed to capture screenshot")
+func notModifiedSince(r *http.Request, modified time.Time) bool {
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(t)
 }
 
-func (s *Server) writeResponse(w http.ResponseWriter, screenshot []byte, etag string, timing Timing) {
-	w.Header().Set("Content-Type", "image/webp")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.config.CacheTTLSecs))
-	w.Header().Set("ETag", etag)
+func setTimingHeaders(w http.ResponseWriter, timing Timing) {
+	w.Header().Set("X-Queue-Ms", strconv.FormatInt(timing.Queue.Milliseconds(), 10))
 	w.Header().Set("X-Setup-Ms", strconv.FormatInt(timing.Setup.Milliseconds(), 10))
 	w.Header().Set("X-Nav-Ms", strconv.FormatInt(timing.Navigation.Milliseconds(), 10))
 	w.Header().Set("X-Load-Ms", strconv.FormatInt(timing.Load.Milliseconds(), 10))
 	w.Header().Set("X-Screenshot-Ms", strconv.FormatInt(timing.Screenshot.Milliseconds(), 10))
 	w.Header().Set("X-Total-Ms", strconv.FormatInt(timing.Total.Milliseconds(), 10))
+}
 
-	if _, err := w.Write(screenshot); err != nil {
-		s.logger.Error("failed to write response", slog.String("error", err.Error()))
+func contentDisposition(target string, opts CaptureOptions, download bool) string {
+	host := "page"
+	if u, err := url.Parse(target); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	host = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '-'
+		}
+	}, host)
+	if len(host) > maxFilenameHostLen {
+		host = host[:maxFilenameHostLen]
 	}
+
+	filename := fmt.Sprintf("screenshot-%s-%dx%d.%s", host, opts.Width, opts.Height, opts.Format())
+
+	if download {
+		return `attachment; filename="` + filename + `"`
+	}
+	return `inline; filename="` + filename + `"`
 }
 
-func (s *Server) writeCachedResponse(w http.ResponseWriter, data []byte, contentType, etag string) {
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.config.CacheTTLSecs))
-	w.Header().Set("ETag", etag)
-	w.Header().Set("X-Cache", "HIT")
+func setImageDimensionHeaders(w http.ResponseWriter, data []byte) {
+	width, height, err := parseWebPDimensions(data)
+	if err != nil {
+		return
+	}
+	w.Header().Set("X-Image-Width", strconv.Itoa(width))
+	w.Header().Set("X-Image-Height", strconv.Itoa(height))
+}
 
-	if _, err := w.Write(data); err != nil {
-		s.logger.Error("failed to write cached response", slog.String("error", err.Error()))
+func parseWebPDimensions(data []byte) (w, h int, err error) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errors.New("not a webp image")
+	}
+
+	switch string(data[12:16]) {
+	case "VP8 ":
+		if data[23] != 0x9d || data[24] != 0x01 || data[25] != 0x2a {
+			return 0, 0, errors.New("invalid VP8 start code")
+		}
+		w = int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff)
+		h = int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff)
+	case "VP8L":
+		if data[20] != 0x2f {
+			return 0, 0, errors.New("invalid VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		w = int(bits&0x3fff) + 1
+		h = int((bits>>14)&0x3fff) + 1
+	case "VP8X":
+		w = int(uint32(data[24])|uint32(data[25])<<8|uint32(data[26])<<16) + 1
+		h = int(uint32(data[27])|uint32(data[28])<<8|uint32(data[29])<<16) + 1
+	default:
+		return 0, 0, fmt.Errorf("unsupported webp chunk %q", data[12:16])
 	}
+
+	return w, h, nil
 }
 
 func (s *Server) isBot(userAgent string) bool {
 	return len(userAgent) < s.config.MinUserAgentLen || botPattern.MatchString(userAgent)
 }
 
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func generateUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
 func generateRandomString(length int) string {
 	bytes := make([]byte, length)
 	if _, err := rand.Read(bytes); err != nil {
@@ -828,25 +4795,74 @@ func generateRandomString(length int) string {
 
 func (s *Server) basicAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if s.config.Password == "" {
+		if s.authenticate(w, r) {
 			next(w, r)
-			return
 		}
+	}
+}
 
-		if r.Header.Get("X-API-Key") == s.config.Password {
-			next(w, r)
-			return
-		}
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if s.config.Password == "" {
+		return true
+	}
 
-		_, pass, ok := r.BasicAuth()
-		if !ok || pass != s.config.Password {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			s.handleError(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
+	if r.Header.Get("X-API-Key") == s.config.Password {
+		return true
+	}
 
-		next(w, r)
+	if s.config.SessionCookieTTL > 0 && s.validSession(r) {
+		return true
+	}
+
+	_, pass, ok := r.BasicAuth()
+	if !ok || pass != s.config.Password {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", cmp.Or(s.config.BasicAuthRealm, defaultBasicAuthRealm)))
+		s.handleError(w, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+
+	if s.config.SessionCookieTTL > 0 {
+		expires := time.Now().Add(s.config.SessionCookieTTL)
+		s.setCookie(w, sessionCookieName, s.sessionToken(expires), int(s.config.SessionCookieTTL.Seconds()))
+	}
+	return true
+}
+
+func (s *Server) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.config.TLSEnabled(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) sessionToken(expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(s.config.Password))
+	mac.Write([]byte(exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) validSession(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
 	}
+
+	exp, _, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return false
+	}
+
+	return hmac.Equal([]byte(c.Value), []byte(s.sessionToken(time.Unix(unix, 0))))
 }
 
 func applyPragmas(db *sql.DB) error {
@@ -864,16 +4880,51 @@ func applyPragmas(db *sql.DB) error {
 		}
 	}
 
+	return quickCheck(db)
+}
+
+func quickCheck(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA quick_check")
+	if err != nil {
+		return fmt.Errorf("failed to run quick_check: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("failed to read quick_check result: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read quick_check result: %w", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrDatabaseCorrupt, strings.Join(problems, "; "))
+	}
 	return nil
 }
 
-func runMigrations(db *sql.DB) error {
+func setupGoose() error {
 	goose.SetBaseFS(assets.EmbeddedFiles)
 
 	if err := goose.SetDialect("sqlite3"); err != nil {
 		return fmt.Errorf("failed to set goose dialect: %w", err)
 	}
 
+	return nil
+}
+
+func runMigrations(db *sql.DB) error {
+	if err := setupGoose(); err != nil {
+		return err
+	}
+
 	if err := goose.Up(db, "migrations"); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
@@ -883,7 +4934,7 @@ func runMigrations(db *sql.DB) error {
 
 func parseTemplates() (map[string]*template.Template, error) {
 	templates := make(map[string]*template.Template)
-	pages := []string{"index", "404", "500", "error", "screenshots"}
+	pages := []string{"index", "404", "500", "error", "screenshots", "preview"}
 
 	base, err := assets.EmbeddedFiles.ReadFile("templates/base.html")
 	if err != nil {
@@ -912,26 +4963,126 @@ func parseTemplates() (map[string]*template.Template, error) {
 	return templates, nil
 }
 
+func hostAllowed(host string, allowed []string) bool {
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func targetURLErrorStatus(err error) int {
+	if errors.Is(err, ErrHostNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func firstPartyDomain(rawURL string) string {
+	host := extractHost(rawURL)
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+func isThirdParty(reqURL string, reqType proto.NetworkResourceType, firstParty string) bool {
+	if reqType == proto.NetworkResourceTypeStylesheet || reqType == proto.NetworkResourceTypeDocument {
+		return false
+	}
+	if !strings.HasPrefix(reqURL, "http://") && !strings.HasPrefix(reqURL, "https://") {
+		return false
+	}
+	host := extractHost(reqURL)
+	return host != firstParty && !strings.HasSuffix(host, "."+firstParty)
+}
+
 func extractHost(rawURL string) string {
 	u := rawURL
 	if idx := strings.Index(u, "://"); idx != -1 {
 		u = u[idx+3:]
 	}
-	if idx := strings.IndexByte(u, '/'); idx != -1 {
+	if idx := strings.IndexAny(u, "/?#"); idx != -1 {
 		u = u[:idx]
 	}
+	if idx := strings.LastIndexByte(u, '@'); idx != -1 {
+		u = u[idx+1:]
+	}
+	if strings.HasPrefix(u, "[") {
+		if idx := strings.IndexByte(u, ']'); idx != -1 {
+			return strings.ToLower(u[1:idx])
+		}
+	}
 	if idx := strings.IndexByte(u, ':'); idx != -1 {
 		u = u[:idx]
 	}
 	return strings.ToLower(u)
 }
 
-func generateETag(url string, width, height int) string {
-	h := fnv.New64a()
-	h.Write([]byte(url))
-	h.Write([]byte(fmt.Sprintf("%d:%d", width, height)))
-	h.Write([]byte(time.Now().Format("2006-01-02-15")))
-	return strconv.FormatUint(h.Sum64(), 36)
+func CacheKey(url string, width, height int, format string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s\x00%d\x00%d\x00%s", len(url), url, width, height, format)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func cacheKeyFor(url string, width, height int, format, variant string) string {
+	if variant != "" {
+		format += ";" + variant
+	}
+	return CacheKey(url, width, height, format)
+}
+
+func generateETag(url string, width, height int, format, variant string, createdAt time.Time) string {
+	sum := sha256.Sum256([]byte(cacheKeyFor(url, width, height, format, variant) + "|" + strconv.FormatInt(createdAt.Unix(), 10)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func clampPageTimeout(secs int, maxTimeout time.Duration) time.Duration {
+	timeout := time.Duration(secs) * time.Second
+	if timeout < minPageTimeout {
+		return minPageTimeout
+	}
+	if timeout > maxTimeout {
+		return maxTimeout
+	}
+	return timeout
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+func lookupPreset(name string) (Dimension, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	dim, ok := presets[name]
+	return dim, ok
+}
+
+func normalizeURL(rawURL string) string {
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		return "https://" + rawURL
+	}
+	return rawURL
+}
+
+func clampDimension(n, defaultVal, maxVal int) int {
+	if n <= 0 {
+		return defaultVal
+	}
+	if n > maxVal {
+		return maxVal
+	}
+	return n
 }
 
 func parseIntParam(r *http.Request, name string, defaultVal, maxVal int) int {
@@ -949,28 +5100,110 @@ func parseIntParam(r *http.Request, name string, defaultVal, maxVal int) int {
 	return n
 }
 
+// migrateOnStart applies pending migrations, or with autoMigrate off refuses
+// to serve from a schema older than the binary expects.
+func migrateOnStart(repo *ScreenshotRepository, autoMigrate bool) error {
+	if autoMigrate {
+		if err := repo.MigrateUp(); err != nil {
+			return fmt.Errorf("migrating database: %w", err)
+		}
+		return nil
+	}
+
+	version, err := repo.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version < latest {
+		return fmt.Errorf("database schema is at version %d but %d is required, run with -migrate first", version, latest)
+	}
+	return nil
+}
+
 func run() error {
+	migrate := flag.Bool("migrate", false, "run database migrations and exit")
+	warmUpOnly := flag.Bool("warm-up-only", false, "warm the screenshot cache from the warm-up file and exit")
+	configPath := flag.String("config", "", "TOML config file; APP_* environment variables override its values")
+	flag.Parse()
+
 	cfg := DefaultConfig()
+	if *configPath != "" {
+		var err error
+		if cfg, err = LoadConfig(*configPath); err != nil {
+			return err
+		}
+	}
 
 	logLevel := slog.LevelInfo
 	if cfg.Debug {
 		logLevel = slog.LevelDebug
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: logLevel,
-	}))
+	})
+	if cfg.AllowTargetAuth || cfg.AllowBrowserInteraction {
+		handler = newRedactHandler(handler)
+	}
+	logger := slog.New(handler)
 
+	if cfg.EnablePprof && cfg.Password == "" {
+		return errors.New("enable_pprof requires a password, the profiles would otherwise be public")
+	}
 	if cfg.Password == "" {
 		cfg.Password = generateRandomString(24)
 		logger.Info("generated app password", slog.String("password", cfg.Password))
 	}
 
-	repo, err := NewScreenshotRepository("./data/db.sqlite?cache=shared&mode=rwc&_journal_mode=WAL")
+	shutdownTracing, err := setupTracing(context.Background(), cfg.OTELEndpoint, logger)
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", slog.String("error", err.Error()))
+		}
+	}()
+
+	repo, err := OpenScreenshotRepository("./data/db.sqlite?cache=shared&mode=rwc&_journal_mode=WAL", cfg)
+	if errors.Is(err, ErrDatabaseCorrupt) {
+		logger.Error("refusing to start with a corrupt database", slog.String("error", err.Error()))
+	}
 	if err != nil {
 		return fmt.Errorf("creating repository: %w", err)
 	}
 	defer repo.Close()
+	repo.maxEntries, repo.evictBatch = cfg.MaxCacheEntries, cfg.EvictBatchSize
+
+	if *migrate {
+		if err := repo.MigrateUp(); err != nil {
+			return fmt.Errorf("migrating database: %w", err)
+		}
+		version, err := repo.SchemaVersion()
+		if err != nil {
+			return fmt.Errorf("reading schema version: %w", err)
+		}
+		logger.Info("database migrated", slog.Int("version", version))
+		return nil
+	}
+	if err := migrateOnStart(repo, cfg.AutoMigrate); err != nil {
+		return err
+	}
+	repo.StartSweeper(cacheSweepInterval, logger)
+
+	if cfg.WarmUpFile != "" {
+		urls, err := loadWarmUpURLs(cfg.WarmUpFile)
+		if err != nil {
+			return err
+		}
+		cfg.WarmUpURLs = urls
+	}
 
 	srv, err := NewServer(cfg, logger, repo)
 	if err != nil {
@@ -978,25 +5211,76 @@ func run() error {
 	}
 	defer srv.Close()
 
+	srv.warmUp(cfg.WarmUpURLs)
+	if *warmUpOnly {
+		return nil
+	}
+
 	mux := http.NewServeMux()
 	srv.ServeHTTP(mux)
 
 	httpServer := &http.Server{
 		Addr:         cfg.Port,
-		Handler:      mux,
+		Handler:      withSecurityHeaders(srv.withRequestID(srv.accessLogger(withTracing(mux)))),
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
 	}
 
-	errChan := make(chan error, 1)
+	var redirectServer *http.Server
+	if cfg.TLSEnabled() {
+		if cfg.HTTPRedirectPort == cfg.Port {
+			return fmt.Errorf("http redirect port %s conflicts with server port", cfg.HTTPRedirectPort)
+		}
+
+		var redirect http.Handler = httpsRedirectHandler(cfg.Port)
+		if cfg.TLSAutoACME {
+			if cfg.ACMEDomain == "" {
+				return errors.New("acme domain is required for automatic TLS")
+			}
+			manager := &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				HostPolicy: autocert.HostWhitelist(cfg.ACMEDomain),
+				Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			}
+			httpServer.TLSConfig = manager.TLSConfig()
+			redirect = manager.HTTPHandler(redirect)
+		}
+
+		redirectServer = &http.Server{
+			Addr:         cfg.HTTPRedirectPort,
+			Handler:      redirect,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
+	}
+
+	errChan := make(chan error, 2)
 	go func() {
-		logger.Info("server starting", slog.String("addr", cfg.Port))
-		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Info("server starting", slog.String("addr", cfg.Port), slog.Bool("tls", cfg.TLSEnabled()))
+		var err error
+		if cfg.TLSAutoACME {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else if cfg.TLSEnabled() {
+			err = httpServer.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
 	}()
 
+	if redirectServer != nil {
+		go func() {
+			logger.Info("http redirect starting", slog.String("addr", cfg.HTTPRedirectPort))
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- err
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
@@ -1009,6 +5293,13 @@ func run() error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("shutdown error: %w", err)
+		}
+	}
 
 	if err := httpServer.Shutdown(ctx); err != nil {
 		return fmt.Errorf("shutdown error: %w", err)
@@ -1019,6 +5310,14 @@ func run() error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "capture" {
+		if err := runCapture(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+)
+
+var (
+	credentialParams = regexp.MustCompile(`\b(auth_user|auth_pass|login_user|login_pass)=[^&\s"]*`)
+	urlUserinfo      = regexp.MustCompile(`(://)[^/@\s"]*@`)
+)
+
+type redactHandler struct {
+	slog.Handler
+}
+
+func newRedactHandler(h slog.Handler) slog.Handler {
+	return redactHandler{Handler: h}
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redactCredentials(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return redactHandler{Handler: h.Handler.WithAttrs(redacted)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactCredentials(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redacted[i] = redactAttr(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	}
+	return a
+}
+
+func redactCredentials(s string) string {
+	s = credentialParams.ReplaceAllString(s, "${1}=REDACTED")
+	return urlUserinfo.ReplaceAllString(s, "${1}REDACTED@")
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const redisKeyPrefix = "screenshot:"
+
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+	logger *slog.Logger
+	flight singleflight.Group
+}
+
+type redisEntry struct {
+	URL         string    `json:"url"`
+	Data        []byte    `json:"data"`
+	ContentType string    `json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Timing      Timing    `json:"timing"`
+	CreatedAt   time.Time `json:"created_at"`
+	TTL         int64     `json:"ttl_secs,omitempty"`
+}
+
+func NewRedisStore(redisURL string, ttl time.Duration, logger *slog.Logger) (*RedisStore, error) {
+	if redisURL == "" {
+		return nil, errors.New("redis url is required")
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis url: %w", err)
+	}
+
+	store := &RedisStore{
+		client: redis.NewClient(opts),
+		ttl:    ttl,
+		logger: logger,
+	}
+
+	if err := store.Ping(); err != nil {
+		store.client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	return store, nil
+}
+
+func redisKey(url string, width, height int, format, variant string) string {
+	sum := sha256.Sum256([]byte(url))
+	key := redisKeyPrefix + hex.EncodeToString(sum[:]) + ":" + strconv.Itoa(width) + ":" + strconv.Itoa(height) + ":" + format
+	if variant != "" {
+		v := sha256.Sum256([]byte(variant))
+		key += ":" + hex.EncodeToString(v[:8])
+	}
+	return key
+}
+
+func (s *RedisStore) Get(url string, width, height int, format, variant string) (CachedScreenshot, error) {
+	return s.GetWithTTL(url, width, height, format, variant, 0)
+}
+
+func (s *RedisStore) GetWithTTL(url string, width, height int, format, variant string, maxAge time.Duration) (CachedScreenshot, error) {
+	var shot CachedScreenshot
+
+	data, err := s.client.Get(context.Background(), redisKey(url, width, height, format, variant)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return shot, ErrNotFound
+		}
+		return shot, fmt.Errorf("failed to get screenshot: %w", err)
+	}
+
+	var entry redisEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return shot, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+	if maxAge > 0 && entry.TTL == 0 && time.Since(entry.CreatedAt) > maxAge {
+		return shot, ErrNotFound
+	}
+
+	return CachedScreenshot{
+		Data:        entry.Data,
+		ContentType: entry.ContentType,
+		Timing:      entry.Timing,
+		CreatedAt:   entry.CreatedAt,
+		TTL:         time.Duration(entry.TTL) * time.Second,
+	}, nil
+}
+
+func (s *RedisStore) Save(url string, shot CachedScreenshot, width, height int, format, variant string) error {
+	createdAt := shot.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	entry := redisEntry{
+		URL:         url,
+		Data:        shot.Data,
+		ContentType: shot.ContentType,
+		Width:       width,
+		Height:      height,
+		Timing:      shot.Timing,
+		CreatedAt:   createdAt.UTC(),
+		TTL:         int64(shot.TTL.Seconds()),
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode screenshot: %w", err)
+	}
+
+	ttl := s.ttl
+	if shot.TTL > 0 {
+		ttl = shot.TTL
+	}
+
+	if err := s.client.SetEx(context.Background(), redisKey(url, width, height, format, variant), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save screenshot: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) GetOrCreate(url string, width, height int, format, variant string, maxAge time.Duration, fn func() (CachedScreenshot, error)) (CachedScreenshot, bool, error) {
+	return getOrCreate(s, &s.flight, url, width, height, format, variant, maxAge, fn)
+}
+
+func (s *RedisStore) List(offset, limit int, order ListOrder) ([]ScreenshotMeta, int64, error) {
+	s.logger.Warn("listing redis screenshots scans every key and is O(N)")
+
+	ctx := context.Background()
+	entries := []ScreenshotMeta{}
+
+	iter := s.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.client.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return nil, 0, fmt.Errorf("failed to list screenshots: %w", err)
+		}
+
+		var entry redisEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode screenshot: %w", err)
+		}
+
+		entries = append(entries, ScreenshotMeta{
+			URL:         entry.URL,
+			DataSize:    len(entry.Data),
+			ContentType: entry.ContentType,
+			Width:       entry.Width,
+			Height:      entry.Height,
+			CreatedAt:   entry.CreatedAt.Format(time.DateTime),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list screenshots: %w", err)
+	}
+
+	slices.SortStableFunc(entries, order.compare)
+
+	total := int64(len(entries))
+	return entries[min(offset, len(entries)):min(offset+limit, len(entries))], total, nil
+}
+
+func (s *RedisStore) CountByURLPrefix(prefix string) (int64, error) {
+	keys, err := s.keysByURLPrefix(context.Background(), prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count screenshots: %w", err)
+	}
+	return int64(len(keys)), nil
+}
+
+func (s *RedisStore) PurgeByURLPrefix(prefix string) (int64, error) {
+	ctx := context.Background()
+	keys, err := s.keysByURLPrefix(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge screenshots: %w", err)
+	}
+
+	var deleted int64
+	for chunk := range slices.Chunk(keys, 100) {
+		n, err := s.client.Del(ctx, chunk...).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to purge screenshots: %w", err)
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+func (s *RedisStore) Latest(url string) ([]byte, string, time.Time, error) {
+	s.logger.Warn("finding the latest redis screenshot scans every key and is O(N)")
+
+	var latest *redisEntry
+	err := s.eachEntry(context.Background(), func(_ string, entry redisEntry) {
+		if entry.URL == url && (latest == nil || entry.CreatedAt.After(latest.CreatedAt)) {
+			latest = &entry
+		}
+	})
+	if err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("failed to get latest screenshot: %w", err)
+	}
+	if latest == nil {
+		return nil, "", time.Time{}, ErrNotFound
+	}
+	return latest.Data, latest.ContentType, latest.CreatedAt, nil
+}
+
+func (s *RedisStore) keysByURLPrefix(ctx context.Context, prefix string) ([]string, error) {
+	s.logger.Warn("matching redis screenshots by url prefix scans every key and is O(N)")
+
+	var keys []string
+	err := s.eachEntry(ctx, func(key string, entry redisEntry) {
+		if strings.HasPrefix(entry.URL, prefix) {
+			keys = append(keys, key)
+		}
+	})
+	return keys, err
+}
+
+// Keys hash the url, so finding entries by url means decoding every one.
+func (s *RedisStore) eachEntry(ctx context.Context, fn func(key string, entry redisEntry)) error {
+	iter := s.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.client.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return err
+		}
+
+		var entry redisEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("failed to decode screenshot: %w", err)
+		}
+		fn(iter.Val(), entry)
+	}
+	return iter.Err()
+}
+
+func (s *RedisStore) Ping() error {
+	return s.client.Ping(context.Background()).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
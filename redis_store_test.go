@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://"+mr.Addr(), time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store, mr
+}
+
+func TestRedisStoreSaveAndGet(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	if _, err := store.Get("https://example.com", 800, 420, "webp", ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	shot := CachedScreenshot{
+		Data:        []byte("image"),
+		ContentType: "image/webp",
+		Timing:      Timing{Total: 250 * time.Millisecond},
+	}
+	if err := store.Save("https://example.com", shot, 800, 420, "webp", ""); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	got, err := store.Get("https://example.com", 800, 420, "webp", "")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if string(got.Data) != "image" {
+		t.Errorf("expected data %q, got %q", "image", got.Data)
+	}
+	if got.Timing.Total != 250*time.Millisecond {
+		t.Errorf("expected total timing 250ms, got %v", got.Timing.Total)
+	}
+
+	if _, err := store.Get("https://example.com", 800, 420, "webp", "q=90"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected variant to be cached separately, got %v", err)
+	}
+}
+
+func TestRedisStoreTTL(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+
+	shot := CachedScreenshot{Data: []byte("image"), ContentType: "image/webp"}
+	if err := store.Save("https://example.com", shot, 800, 420, "webp", ""); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	mr.FastForward(2 * time.Hour)
+
+	if _, err := store.Get("https://example.com", 800, 420, "webp", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected entry to expire, got %v", err)
+	}
+}
+
+func TestRedisStoreGetWithTTL(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	shot := CachedScreenshot{Data: []byte("image"), ContentType: "image/webp"}
+	if err := store.Save("https://example.com/stale", shot, 800, 420, "webp", ""); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	shot.TTL = time.Hour
+	if err := store.Save("https://example.com/ttl", shot, 800, 420, "webp", ""); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if _, err := store.GetWithTTL("https://example.com/stale", 800, 420, "webp", "", time.Nanosecond); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an entry older than the max age to be a miss, got %v", err)
+	}
+	if _, err := store.GetWithTTL("https://example.com/stale", 800, 420, "webp", "", time.Hour); err != nil {
+		t.Errorf("expected a fresh entry, got %v", err)
+	}
+	got, err := store.GetWithTTL("https://example.com/ttl", 800, 420, "webp", "", time.Nanosecond)
+	if err != nil {
+		t.Fatalf("expected an entry with its own ttl to ignore the max age, got %v", err)
+	}
+	if got.TTL != time.Hour {
+		t.Errorf("expected ttl 1h, got %s", got.TTL)
+	}
+}
+
+func TestRedisStoreList(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	for _, u := range []string{"https://a.com", "https://b.com"} {
+		if err := store.Save(u, CachedScreenshot{Data: []byte("img"), ContentType: "image/webp"}, 800, 420, "webp", ""); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+	}
+
+	entries, total, err := store.List(0, 1, ListOrder{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("expected total 2, got %d", total)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected 1 entry on the first page, got %d", len(entries))
+	}
+
+	entries, _, err = store.List(5, 1, ListOrder{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected empty page past the end, got %d", len(entries))
+	}
+}
+
+func TestRedisStorePurgeByURLPrefix(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	shot := CachedScreenshot{Data: []byte("image"), ContentType: "image/webp"}
+	for _, url := range []string{"https://example.com/a", "https://example.com/b", "https://other.com"} {
+		if err := store.Save(url, shot, 800, 420, "webp", ""); err != nil {
+			t.Fatalf("failed to save %s: %v", url, err)
+		}
+	}
+	if err := store.Save("https://example.com/a", shot, 800, 420, "webp", "q=90"); err != nil {
+		t.Fatalf("failed to save variant: %v", err)
+	}
+
+	count, err := store.CountByURLPrefix("https://example.com/")
+	if err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 matching screenshots, got %d", count)
+	}
+
+	deleted, err := store.PurgeByURLPrefix("https://example.com/")
+	if err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected 3 deleted, got %d", deleted)
+	}
+	if _, err := store.Get("https://example.com/a", 800, 420, "webp", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected purged screenshot to be gone, got %v", err)
+	}
+	if _, err := store.Get("https://other.com", 800, 420, "webp", ""); err != nil {
+		t.Errorf("expected other host to survive the purge, got %v", err)
+	}
+}
+
+func TestRedisStoreLatest(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	if _, _, _, err := store.Latest("https://example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	older := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	shots := []struct {
+		shot   CachedScreenshot
+		format string
+	}{
+		{CachedScreenshot{Data: []byte("old"), ContentType: "image/webp", CreatedAt: older}, "webp"},
+		{CachedScreenshot{Data: []byte("new"), ContentType: "image/png", CreatedAt: older.Add(time.Hour)}, "png"},
+	}
+	for _, tt := range shots {
+		if err := store.Save("https://example.com", tt.shot, 800, 420, tt.format, ""); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+	}
+
+	data, contentType, createdAt, err := store.Latest("https://example.com")
+	if err != nil {
+		t.Fatalf("failed to get latest: %v", err)
+	}
+	if string(data) != "new" || contentType != "image/png" || !createdAt.Equal(older.Add(time.Hour)) {
+		t.Errorf("expected the newest png, got %q %s %s", data, contentType, createdAt)
+	}
+}
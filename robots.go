@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	robotsFetchTimeout  = 2 * time.Second
+	maxRobotsBytes      = 512 * 1024
+	maxRobotsRedirects  = 5
+	robotsSweepInterval = time.Minute
+)
+
+var (
+	ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+	errPrivateAddress   = errors.New("refusing to connect to a private network address")
+)
+
+type robotsEntry struct {
+	disallowed bool
+	expires    time.Time
+}
+
+func (s *Server) checkRobots(ctx context.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.sweepRobots(now)
+
+	// robots.txt is per origin, so http and https answers are kept apart.
+	key := u.Scheme + "://" + u.Host
+	if v, ok := s.robots.Load(key); ok {
+		if entry := v.(robotsEntry); now.Before(entry.expires) {
+			if entry.disallowed {
+				return ErrRobotsDisallowed
+			}
+			return nil
+		}
+		s.robots.Delete(key)
+	}
+
+	disallowed := s.fetchRobots(ctx, u)
+	s.robots.Store(key, robotsEntry{disallowed: disallowed, expires: time.Now().Add(s.config.RobotsTTL)})
+
+	if disallowed {
+		return ErrRobotsDisallowed
+	}
+	return nil
+}
+
+// sweepRobots drops expired entries at most once per robotsSweepInterval, so
+// hosts that are never asked for again do not stay cached forever.
+func (s *Server) sweepRobots(now time.Time) {
+	last := s.robotsSwept.Load()
+	if now.UnixNano()-last < int64(robotsSweepInterval) || !s.robotsSwept.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	s.robots.Range(func(key, v any) bool {
+		if !now.Before(v.(robotsEntry).expires) {
+			s.robots.Delete(key)
+		}
+		return true
+	})
+}
+
+func (s *Server) fetchRobots(ctx context.Context, u *url.URL) bool {
+	ctx, cancel := context.WithTimeout(ctx, robotsFetchTimeout)
+	defer cancel()
+
+	robotsURL := u.Scheme + "://" + u.Host + "/robots.txt"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", s.config.RobotsFetchUA)
+
+	resp, err := s.robotsClient.Do(req)
+	if err != nil {
+		s.loggerFrom(ctx).Debug("failed to fetch robots.txt", slog.String("url", robotsURL), slog.String("error", err.Error()))
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	return robotsDisallowsAll(io.LimitReader(resp.Body, maxRobotsBytes), s.config.RobotsFetchUA, s.config.BrowserUserAgent)
+}
+
+// newRobotsClient returns the client robots.txt is fetched with. The target
+// host is picked by the caller, so unless private addresses are allowed the
+// dialer refuses them after resolution and redirects are checked the same way
+// validateTargetURL checks the capture URL.
+func newRobotsClient(allowPrivateIPs bool) *http.Client {
+	dialer := &net.Dialer{Timeout: robotsFetchTimeout}
+	if !allowPrivateIPs {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRobotsRedirects {
+				return errors.New("too many robots.txt redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("robots.txt redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			if allowPrivateIPs {
+				return nil
+			}
+			if private, err := resolvesToPrivateIP(req.Context(), req.URL.Hostname()); err != nil || private {
+				return fmt.Errorf("robots.txt redirect to %s: %w", req.URL.Host, errPrivateAddress)
+			}
+			return nil
+		},
+	}
+}
+
+func robotsDisallowsAll(r io.Reader, userAgents ...string) bool {
+	var tokens []string
+	for _, ua := range userAgents {
+		token, _, _ := strings.Cut(strings.ToLower(ua), "/")
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	var agents []string
+	inRules := false
+	wildcard, specific, matched := false, false, false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			for _, agent := range agents {
+				blocksAll := key == "disallow" && value == "/"
+				if agent == "*" {
+					wildcard = wildcard || blocksAll
+				} else if slices.Contains(tokens, agent) {
+					matched = true
+					specific = specific || blocksAll
+				}
+			}
+		}
+	}
+
+	if matched {
+		return specific
+	}
+	return wildcard
+}
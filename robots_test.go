@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRobotsDisallowsAll(t *testing.T) {
+	tests := []struct {
+		name      string
+		robots    string
+		userAgent string
+		expected  bool
+	}{
+		{"empty", "", "", false},
+		{"wildcard disallow all", "User-agent: *\nDisallow: /", "", true},
+		{"wildcard disallow path", "User-agent: *\nDisallow: /admin", "", false},
+		{"other agent disallowed", "User-agent: Googlebot\nDisallow: /", "", false},
+		{"configured agent disallowed", "User-agent: ScreenshotBot\nDisallow: /\n\nUser-agent: *\nAllow: /", "ScreenshotBot/1.0", true},
+		{"configured agent allowed over wildcard", "User-agent: *\nDisallow: /\n\nUser-agent: screenshotbot\nAllow: /", "ScreenshotBot/1.0", false},
+		{"grouped agents", "User-agent: foo\nUser-agent: *\nDisallow: / # everything", "", true},
+		{"case insensitive keys", "USER-AGENT: *\ndisallow: /", "", true},
+		{"robots fetch agent disallowed", "User-agent: screenshotbot\nDisallow: /", "screenshotbot/1.0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := robotsDisallowsAll(strings.NewReader(tt.robots), tt.userAgent); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheckRobotsCachesByHost(t *testing.T) {
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path != "/robots.txt" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if ua := r.Header.Get("User-Agent"); ua != "screenshotbot/1.0" {
+			t.Errorf("expected robots user agent, got %q", ua)
+		}
+		w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	}))
+	defer ts.Close()
+
+	s := &Server{
+		config:       Config{RobotsFetchUA: "screenshotbot/1.0", RobotsTTL: time.Hour},
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		robotsClient: newRobotsClient(true),
+	}
+
+	for range 3 {
+		if err := s.checkRobots(context.Background(), ts.URL+"/page"); !errors.Is(err, ErrRobotsDisallowed) {
+			t.Fatalf("expected ErrRobotsDisallowed, got %v", err)
+		}
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got %d", n)
+	}
+
+	s.config.RobotsTTL = 0
+	s.robots.Clear()
+	s.checkRobots(context.Background(), ts.URL+"/page")
+	s.checkRobots(context.Background(), ts.URL+"/page")
+	if n := fetches.Load(); n != 3 {
+		t.Errorf("expected expired entries to be refetched, got %d fetches", n)
+	}
+}
+
+func TestFetchRobotsRefusesPrivateAddresses(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+		w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	}))
+	defer internal.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/latest/meta-data", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	s := &Server{
+		config: Config{RobotsFetchUA: "screenshotbot/1.0", RobotsTTL: time.Hour},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// The test servers are on loopback, so the first hop is let through by
+	// an unguarded transport to exercise the redirect check on its own.
+	s.robotsClient = newRobotsClient(false)
+	s.robotsClient.Transport = http.DefaultTransport
+	if err := s.checkRobots(context.Background(), redirector.URL+"/page"); err != nil {
+		t.Errorf("expected a refused redirect to allow the capture, got %v", err)
+	}
+	if n := internalHits.Load(); n != 0 {
+		t.Errorf("expected the redirect to a loopback address not to be followed, got %d requests", n)
+	}
+
+	s.robotsClient = newRobotsClient(false)
+	s.robots.Clear()
+	if err := s.checkRobots(context.Background(), internal.URL+"/page"); err != nil {
+		t.Errorf("expected a refused fetch to allow the capture, got %v", err)
+	}
+	if n := internalHits.Load(); n != 0 {
+		t.Errorf("expected the dialer to refuse a loopback address, got %d requests", n)
+	}
+}
+
+func TestCheckRobotsKeysAndSweeps(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	}))
+	defer ts.Close()
+
+	s := &Server{
+		config:       Config{RobotsFetchUA: "screenshotbot/1.0", RobotsTTL: time.Hour},
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		robotsClient: newRobotsClient(true),
+	}
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	s.robots.Store("https://"+host, robotsEntry{expires: time.Now().Add(time.Hour)})
+	if err := s.checkRobots(context.Background(), ts.URL+"/page"); !errors.Is(err, ErrRobotsDisallowed) {
+		t.Errorf("expected the https entry not to answer for http, got %v", err)
+	}
+
+	s.robots.Store("https://stale.example", robotsEntry{expires: time.Now().Add(-time.Minute)})
+	s.robotsSwept.Store(time.Now().Add(-2 * robotsSweepInterval).UnixNano())
+	s.checkRobots(context.Background(), ts.URL+"/page")
+	if _, ok := s.robots.Load("https://stale.example"); ok {
+		t.Error("expected expired entries to be swept")
+	}
+	if _, ok := s.robots.Load("http://" + host); !ok {
+		t.Error("expected the live entry to survive the sweep")
+	}
+}
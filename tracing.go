@@ -0,0 +1,93 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/wajeht/screenshot"
+
+func setupTracing(ctx context.Context, endpoint string, logger *slog.Logger) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating otlp exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "screenshot"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	logger.Info("tracing enabled", slog.String("endpoint", endpoint))
+	return provider.Shutdown, nil
+}
+
+func withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type captureSpan struct {
+	ctx  context.Context
+	span trace.Span
+}
+
+func startCaptureSpan(ctx context.Context, url string) (context.Context, captureSpan) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "capture", trace.WithAttributes(attribute.String("url.full", url)))
+	return ctx, captureSpan{ctx: ctx, span: span}
+}
+
+// The timing phases run back to back and the last one reached ends when
+// capturePage returns, so child spans are laid out backwards from now.
+func (c captureSpan) end(timing Timing, err error) {
+	phases := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"screenshot", timing.Screenshot},
+		{"load", timing.Load},
+		{"navigation", timing.Navigation},
+		{"setup", timing.Setup},
+	}
+
+	tracer := otel.Tracer(tracerName)
+	end := time.Now()
+	for _, phase := range phases {
+		if phase.duration <= 0 {
+			continue
+		}
+		start := end.Add(-phase.duration)
+		_, span := tracer.Start(c.ctx, "capture."+phase.name, trace.WithTimestamp(start))
+		span.End(trace.WithTimestamp(end))
+		end = start
+	}
+
+	if err != nil {
+		c.span.RecordError(err)
+		c.span.SetStatus(codes.Error, err.Error())
+	}
+	c.span.End()
+}
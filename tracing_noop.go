@@ -0,0 +1,28 @@
+//go:build !otel
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+func setupTracing(_ context.Context, endpoint string, logger *slog.Logger) (func(context.Context) error, error) {
+	if endpoint != "" {
+		logger.Warn("APP_OTEL_ENDPOINT is set but this binary was built without the otel tag, tracing is disabled")
+	}
+	return func(context.Context) error { return nil }, nil
+}
+
+func withTracing(next http.Handler) http.Handler {
+	return next
+}
+
+type captureSpan struct{}
+
+func startCaptureSpan(ctx context.Context, _ string) (context.Context, captureSpan) {
+	return ctx, captureSpan{}
+}
+
+func (captureSpan) end(Timing, error) {}
@@ -0,0 +1,76 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestCaptureSpanPhases(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	_, span := startCaptureSpan(context.Background(), "https://example.com")
+	span.end(Timing{Setup: 10 * time.Millisecond, Navigation: 20 * time.Millisecond}, errors.New("load timeout"))
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	root := spans[2]
+	if root.Name() != "capture" {
+		t.Fatalf("expected root span to end last, got %q", root.Name())
+	}
+	if root.Status().Code != codes.Error {
+		t.Errorf("expected root span error status, got %v", root.Status().Code)
+	}
+
+	navigation, setup := spans[0], spans[1]
+	if navigation.Name() != "capture.navigation" || setup.Name() != "capture.setup" {
+		t.Fatalf("unexpected child spans %q, %q", navigation.Name(), setup.Name())
+	}
+	if !setup.EndTime().Equal(navigation.StartTime()) {
+		t.Error("expected phases to be contiguous")
+	}
+	if got := navigation.EndTime().Sub(navigation.StartTime()); got != 20*time.Millisecond {
+		t.Errorf("expected navigation span of 20ms, got %v", got)
+	}
+	for _, child := range spans[:2] {
+		if child.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("expected %q to be a child of capture", child.Name())
+		}
+	}
+}
+
+func TestWithTracingPropagatesTraceparent(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var got trace.SpanContext
+	handler := withTracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected propagated trace id, got %s", got.TraceID())
+	}
+}
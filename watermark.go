@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+var watermarkPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+const watermarkMargin = 16
+
+func loadWatermark(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading watermark: %w", err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("watermark %s is not a png: %w", path, err)
+	}
+	return img, nil
+}
+
+func watermarkOrigin(bounds, mark image.Rectangle, position string) image.Point {
+	w, h := mark.Dx(), mark.Dy()
+	switch position {
+	case "top-left":
+		return image.Pt(bounds.Min.X+watermarkMargin, bounds.Min.Y+watermarkMargin)
+	case "top-right":
+		return image.Pt(bounds.Max.X-watermarkMargin-w, bounds.Min.Y+watermarkMargin)
+	case "bottom-left":
+		return image.Pt(bounds.Min.X+watermarkMargin, bounds.Max.Y-watermarkMargin-h)
+	case "bottom-right":
+		return image.Pt(bounds.Max.X-watermarkMargin-w, bounds.Max.Y-watermarkMargin-h)
+	default:
+		return image.Pt(bounds.Min.X+(bounds.Dx()-w)/2, bounds.Min.Y+(bounds.Dy()-h)/2)
+	}
+}
+
+func compositeWatermark(src, mark image.Image, position string) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	origin := watermarkOrigin(bounds, mark.Bounds(), position)
+	draw.Draw(dst, mark.Bounds().Sub(mark.Bounds().Min).Add(origin), mark, mark.Bounds().Min, draw.Over)
+	return dst
+}
+
+// applyWatermark composites the watermark onto a lossless PNG capture and
+// encodes the result in the requested format. The page itself is never
+// touched, so the target's scripts and CSP cannot interfere with the overlay.
+func applyWatermark(browser *rod.Browser, capture []byte, mark image.Image, position, format string, quality int) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(capture))
+	if err != nil {
+		return nil, fmt.Errorf("decoding capture for watermark: %w", err)
+	}
+	img := compositeWatermark(src, mark, position)
+
+	var buf bytes.Buffer
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	default:
+		return encodeWebP(browser, img, quality)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding watermarked %s: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// There is no pure Go WebP encoder, so the composited image is handed to a
+// blank tab and captured again. The tab is separate from the capture page so
+// none of the target's settings or injected scripts carry over.
+func encodeWebP(browser *rod.Browser, img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encoding watermarked image: %w", err)
+	}
+
+	page, err := browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+	if err != nil {
+		return nil, fmt.Errorf("opening webp encoder page: %w", err)
+	}
+	defer page.Close()
+
+	bounds := img.Bounds()
+	if err := page.SetViewport(&proto.EmulationSetDeviceMetricsOverride{
+		Width:             bounds.Dx(),
+		Height:            bounds.Dy(),
+		DeviceScaleFactor: 1.0,
+	}); err != nil {
+		return nil, fmt.Errorf("setting webp encoder viewport: %w", err)
+	}
+	if err := (proto.EmulationSetDefaultBackgroundColorOverride{Color: &proto.DOMRGBA{}}).Call(page); err != nil {
+		return nil, fmt.Errorf("clearing webp encoder background: %w", err)
+	}
+	if err := page.SetDocumentContent(`<html style="margin:0"><body style="margin:0"><img style="display:block"></body></html>`); err != nil {
+		return nil, fmt.Errorf("preparing webp encoder page: %w", err)
+	}
+	src := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	if _, err := page.Eval(`(src) => { const img = document.querySelector('img'); img.src = src; return img.decode() }`, src); err != nil {
+		return nil, fmt.Errorf("loading watermarked image: %w", err)
+	}
+
+	data, err := page.Screenshot(false, &proto.PageCaptureScreenshot{
+		Format:  proto.PageCaptureScreenshotFormatWebp,
+		Quality: &quality,
+		Clip: &proto.PageViewport{
+			Width:  float64(bounds.Dx()),
+			Height: float64(bounds.Dy()),
+			Scale:  1,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding watermarked webp: %w", err)
+	}
+	return data, nil
+}
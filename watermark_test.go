@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadWatermark(t *testing.T) {
+	dir := t.TempDir()
+
+	logo := filepath.Join(dir, "logo.png")
+	f, err := os.Create(logo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	mark, err := loadWatermark(logo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := mark.Bounds().Size(); got != image.Pt(4, 3) {
+		t.Errorf("expected a 4x3 watermark, got %v", got)
+	}
+
+	notPNG := filepath.Join(dir, "logo.webp")
+	if err := os.WriteFile(notPNG, []byte("RIFF0000WEBP"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadWatermark(notPNG); err == nil || !strings.Contains(err.Error(), "not a png") {
+		t.Errorf("expected a non-png watermark to be rejected, got %v", err)
+	}
+	if _, err := loadWatermark(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("expected a missing watermark to be rejected")
+	}
+}
+
+func TestParseCaptureOptionsWatermark(t *testing.T) {
+	mark := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	tests := []struct {
+		name        string
+		watermark   image.Image
+		query       string
+		wantVariant string
+		wantErr     string
+	}{
+		{"no watermark", mark, "", "", ""},
+		{"bottom-right", mark, "watermark=bottom-right", "watermark=bottom-right", ""},
+		{"center", mark, "watermark=center", "watermark=center", ""},
+		{"not configured", nil, "watermark=top-left", "", "not configured"},
+		{"bad position", mark, "watermark=middle", "", "invalid watermark"},
+		{"with clip", mark, "watermark=top-left&clip=0,0,100,100", "", "cannot be combined"},
+		{"with html", mark, "watermark=top-left&format=html", "", "cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: Config{MaxWidth: 1920, MaxHeight: 1920}, watermark: tt.watermark}
+			opts, err := s.parseCaptureOptions(httptest.NewRequest(http.MethodGet, "/?url=https://example.com&"+tt.query, nil))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := opts.Variant(); got != tt.wantVariant {
+				t.Errorf("expected variant %q, got %q", tt.wantVariant, got)
+			}
+		})
+	}
+}
+
+func TestApplyWatermark(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	mark := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(mark, mark.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+
+	var capture bytes.Buffer
+	if err := png.Encode(&capture, image.NewNRGBA(image.Rect(0, 0, 100, 80))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		position string
+		inside   image.Point
+		outside  image.Point
+	}{
+		{"top-left", image.Pt(16, 16), image.Pt(15, 15)},
+		{"top-right", image.Pt(83, 16), image.Pt(84, 16)},
+		{"bottom-left", image.Pt(16, 63), image.Pt(16, 64)},
+		{"bottom-right", image.Pt(83, 63), image.Pt(84, 64)},
+		{"center", image.Pt(45, 35), image.Pt(44, 34)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.position, func(t *testing.T) {
+			data, err := applyWatermark(nil, capture.Bytes(), mark, tt.position, "png", 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decoding result: %v", err)
+			}
+			if got := color.NRGBAModel.Convert(img.At(tt.inside.X, tt.inside.Y)); got != red {
+				t.Errorf("expected watermark at %v, got %v", tt.inside, got)
+			}
+			if got := color.NRGBAModel.Convert(img.At(tt.outside.X, tt.outside.Y)); got == red {
+				t.Errorf("expected no watermark at %v", tt.outside)
+			}
+		})
+	}
+
+	data, err := applyWatermark(nil, capture.Bytes(), mark, "top-left", "jpeg", 80)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("expected a jpeg, got %v", err)
+	}
+}